@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// CleanAllSnapshot is a clean-all result as it was stored by one refresh. The list of snapshots leaves out the result
+// itself and only shows the counts.
+type CleanAllSnapshot struct {
+	ID          string          `bson:"_id" json:"id"`
+	Created     time.Time       `bson:"created" json:"created"`
+	Window      TimeWindow      `bson:"window" json:"window"`
+	Nodes       int             `bson:"nodes" json:"nodes"`
+	UnusedKeys  int             `bson:"unused_keys" json:"unused_keys"`
+	UnusedPaths int             `bson:"unused_paths" json:"unused_paths"`
+	Result      *CleanAllResult `bson:"result,omitempty" json:"result,omitempty"`
+}
+
+// CleanAllDiff shows what changed between two clean-all snapshots. Keys and paths that are no longer unused were
+// either looked up again or removed from the hiera data.
+type CleanAllDiff struct {
+	From                string    `json:"from"`
+	To                  string    `json:"to"`
+	FromCreated         time.Time `json:"from_created"`
+	ToCreated           time.Time `json:"to_created"`
+	KeysBecameUnused    []string  `json:"keys_became_unused"`
+	KeysNoLongerUnused  []string  `json:"keys_no_longer_unused"`
+	PathsBecameUnused   []string  `json:"paths_became_unused"`
+	PathsNoLongerUnused []string  `json:"paths_no_longer_unused"`
+}
+
+// CleanAllHistoryEndpoint example
+// @Summary Get the history of the clean all results
+// @Description Lists every stored clean all result, newest first, with the amount of unused keys and paths.
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []CleanAllSnapshot
+// @Failure 500 {object} APIMessage "Something went wrong getting the history"
+// @Router /clean-all/history [get]
+func CleanAllHistoryEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		snapshots, err := GetCleanAllSnapshots(conf.DB)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		} else {
+			c.JSON(http.StatusOK, snapshots)
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// CleanAllSnapshotEndpoint example
+// @Summary Get one stored clean all result
+// @Description Shows a clean all result from the history with its unused keys and paths.
+// @Param  id     path   string     true  "Some ID"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} CleanAllSnapshot
+// @Failure 404 {object} APIMessage "The snapshot was not found"
+// @Failure 500 {object} APIMessage "Something went wrong getting the snapshot"
+// @Router /clean-all/history/{id} [get]
+func CleanAllSnapshotEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var u1 JSONID
+		c.ShouldBindUri(&u1)
+		defer c.Done()
+		snapshot, err := GetCleanAllSnapshot(conf.DB, u1.ID)
+		if err == ErrEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": err.Error()})
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		} else {
+			c.JSON(http.StatusOK, snapshot)
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// CleanAllDiffEndpoint example
+// @Summary Compare two clean all results
+// @Description Shows the keys and paths that became unused or are no longer unused between two snapshots. Without parameters the latest snapshot is compared to the one before it.
+// @Param  from   query  string     false "The snapshot id to compare from"
+// @Param  to     query  string     false "The snapshot id to compare to"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} CleanAllDiff
+// @Failure 404 {object} APIMessage "The snapshots were not found"
+// @Failure 500 {object} APIMessage "Something went wrong getting the history"
+// @Router /clean-all/diff [get]
+func CleanAllDiffEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		fromID, toID := c.Query("from"), c.Query("to")
+		if fromID == "" || toID == "" {
+			snapshots, err := GetCleanAllSnapshots(conf.DB)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+				return
+			}
+			if toID == "" && len(snapshots) > 0 {
+				toID = snapshots[0].ID
+			}
+			if fromID == "" {
+				for _, s := range snapshots {
+					if s.ID < toID {
+						fromID = s.ID
+						break
+					}
+				}
+			}
+		}
+		if fromID == "" || toID == "" {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Two snapshots are needed to make a comparison"})
+			return
+		}
+		from, err := GetCleanAllSnapshot(conf.DB, fromID)
+		if err == ErrEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		to, err := GetCleanAllSnapshot(conf.DB, toID)
+		if err == ErrEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, DiffCleanAllSnapshots(*from, *to))
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// DiffCleanAllSnapshots returns the keys and paths that are unused in to but not in from and the other way around
+func DiffCleanAllSnapshots(from CleanAllSnapshot, to CleanAllSnapshot) CleanAllDiff {
+	diff := CleanAllDiff{
+		From:        from.ID,
+		To:          to.ID,
+		FromCreated: from.Created,
+		ToCreated:   to.Created,
+	}
+	fromKeys, toKeys := unusedKeySet(from.Result), unusedKeySet(to.Result)
+	fromPaths, toPaths := unusedPathSet(from.Result), unusedPathSet(to.Result)
+	diff.KeysBecameUnused = setDifference(toKeys, fromKeys)
+	diff.KeysNoLongerUnused = setDifference(fromKeys, toKeys)
+	diff.PathsBecameUnused = setDifference(toPaths, fromPaths)
+	diff.PathsNoLongerUnused = setDifference(fromPaths, toPaths)
+	return diff
+}
+
+func unusedKeySet(r *CleanAllResult) map[string]bool {
+	set := map[string]bool{}
+	if r != nil {
+		for _, k := range r.KeysNeverUsed {
+			set[k.Key] = true
+		}
+	}
+	return set
+}
+
+func unusedPathSet(r *CleanAllResult) map[string]bool {
+	set := map[string]bool{}
+	if r != nil {
+		for _, p := range r.PathsNeverUsed {
+			set[p] = true
+		}
+	}
+	return set
+}
+
+// setDifference returns the sorted values of a that are not in b
+func setDifference(a map[string]bool, b map[string]bool) []string {
+	diff := []string{}
+	for v := range a {
+		if !b[v] {
+			diff = append(diff, v)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// InsertCleanAllSnapshot stores the result as a new snapshot in the history and removes the oldest snapshots when
+// there are more than keep
+func InsertCleanAllSnapshot(d Database, result CleanAllResult, keep int) error {
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	collection := dbConn.Database(d.Database).Collection("cleanhistory")
+	snapshot := CleanAllSnapshot{
+		ID:          primitive.NewObjectID().Hex(),
+		Created:     time.Now(),
+		Window:      result.Window,
+		Nodes:       result.Nodes,
+		UnusedKeys:  len(result.KeysNeverUsed),
+		UnusedPaths: len(result.PathsNeverUsed),
+		Result:      &result,
+	}
+	_, err = collection.InsertOne(context.TODO(), snapshot)
+	if err != nil {
+		return err
+	}
+
+	// the ids sort by creation so everything after the newest keep snapshots goes
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64(keep)).
+		SetProjection(bson.M{"_id": 1})
+	cur, err := collection.Find(context.TODO(), bson.M{}, findOptions)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.TODO())
+	old := []string{}
+	for cur.Next(context.TODO()) {
+		var elem CleanAllSnapshot
+		if err := cur.Decode(&elem); err != nil {
+			log.Println(err.Error())
+		} else {
+			old = append(old, elem.ID)
+		}
+	}
+	if len(old) > 0 {
+		_, err = collection.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": old}})
+	}
+	return err
+}
+
+// GetCleanAllSnapshots gets the history without the results, newest first
+func GetCleanAllSnapshots(d Database) ([]CleanAllSnapshot, error) {
+	snapshots := []CleanAllSnapshot{}
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return snapshots, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetProjection(bson.M{"result": 0})
+	cur, err := dbConn.Database(d.Database).Collection("cleanhistory").Find(context.TODO(), bson.M{}, findOptions)
+	if err != nil {
+		return snapshots, err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var elem CleanAllSnapshot
+		err := cur.Decode(&elem)
+		if err != nil {
+			log.Println(err.Error())
+		} else {
+			snapshots = append(snapshots, elem)
+		}
+	}
+	return snapshots, cur.Err()
+}
+
+// GetCleanAllSnapshot gets one snapshot with its result
+func GetCleanAllSnapshot(d Database, id string) (*CleanAllSnapshot, error) {
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return nil, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	var snapshot CleanAllSnapshot
+	err = dbConn.Database(d.Database).Collection("cleanhistory").FindOne(context.TODO(), bson.M{"_id": id}).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrEntryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,415 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CleanupPatch removes the unused keys and files of the clean all result from the datadir. The diff is a unified diff
+// relative to the datadir that can be applied with git apply or patch -p1.
+type CleanupPatch struct {
+	Success      bool          `json:"success"`
+	Applied      bool          `json:"applied"`
+	RemovedKeys  int           `json:"removed_keys"`
+	RemovedFiles int           `json:"removed_files"`
+	Files        []CleanupFile `json:"files"`
+	Errors       []string      `json:"errors"`
+	Diff         string        `json:"diff"`
+}
+
+// CleanupFile is a hiera file that is changed by a patch. For a cleanup the keys are removed or the whole file is deleted,
+// for a refactoring the keys are renamed or moved.
+type CleanupFile struct {
+	Path    string   `json:"path"`
+	Keys    []string `json:"keys,omitempty"`
+	Deleted bool     `json:"deleted"`
+
+	original []byte
+	cleaned  []byte
+}
+
+// CleanupPatchEndpoint example
+// @Summary Get a patch that removes the unused keys and files
+// @Description This is a dry run. It removes the unused keys and paths of the clean all result from the hiera data, without the ignored ones, and returns the changes as a unified diff. Comments, ordering and anchors of the remaining data are kept. Keys that define an anchor that other keys still use are left alone.
+// @Param  format query  string     false "json (default) or diff for the plain unified diff"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} CleanupPatch
+// @Failure 404 {object} APIMessage "No clean all result was found"
+// @Failure 500 {object} APIMessage "Something went wrong getting the clean all result"
+// @Router /cleanup/patch [get]
+func CleanupPatchEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		patch, err := CreateCleanupPatch(conf)
+		if err == ErrEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": NoCleanAllResultMessage})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		if c.Query("format") == "diff" {
+			c.Data(http.StatusOK, "text/x-diff; charset=utf-8", []byte(patch.Diff))
+			return
+		}
+		c.JSON(http.StatusOK, patch)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// CleanupApplyEndpoint example
+// @Summary Remove the unused keys and files from the datadir
+// @Description Creates the same patch as the dry run and writes it to the datadir. Files that could not be changed are listed in the errors.
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} CleanupPatch
+// @Failure 404 {object} APIMessage "No clean all result was found"
+// @Failure 500 {object} APIMessage "Something went wrong getting the clean all result"
+// @Router /cleanup/apply [post]
+func CleanupApplyEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		patch, err := CreateCleanupPatch(conf)
+		if err == ErrEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": NoCleanAllResultMessage})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		patch.Apply()
+		c.JSON(http.StatusOK, patch)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// CreateCleanupPatch creates the patch from the stored clean all result without the ignored keys and paths
+func CreateCleanupPatch(conf Conf) (*CleanupPatch, error) {
+	full, err := GetFullCleanResultEntry(conf.DB)
+	if full == nil {
+		return nil, err
+	}
+	res := GetIgnoreMatcher(conf).FilterCleanAllResult(*full)
+	return NewCleanupPatch(conf.DataDir, res.KeysNeverUsed, res.PathsNeverUsed), nil
+}
+
+// NewCleanupPatch removes the keys from the paths they are defined in and deletes the paths. Only files inside the
+// datadir are touched. Reserved keys like lookup_options are never removed, they change how the other keys merge.
+func NewCleanupPatch(datadir string, keys []YamlKeyPath, paths []string) *CleanupPatch {
+	patch := &CleanupPatch{Success: true, Files: []CleanupFile{}, Errors: []string{}}
+	deleted := map[string]bool{}
+	for _, p := range paths {
+		if !insideDir(datadir, p) {
+			patch.addError(fmt.Errorf("%s is not inside the datadir", p))
+			continue
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			patch.addError(err)
+			continue
+		}
+		deleted[p] = true
+		patch.Files = append(patch.Files, CleanupFile{Path: p, Deleted: true, original: content})
+	}
+
+	keysPerPath := map[string][]string{}
+	for _, k := range keys {
+		if IsReservedHieraKey(k.Key) {
+			continue
+		}
+		for _, p := range k.Paths {
+			if !deleted[p] {
+				keysPerPath[p] = append(keysPerPath[p], k.Key)
+			}
+		}
+	}
+	for p, remove := range keysPerPath {
+		if !insideDir(datadir, p) {
+			patch.addError(fmt.Errorf("%s is not inside the datadir", p))
+			continue
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			patch.addError(err)
+			continue
+		}
+		cleaned, removed, errs := RemoveYamlKeys(content, remove)
+		for _, err := range errs {
+			patch.addError(fmt.Errorf("%s: %s", p, err.Error()))
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		patch.Files = append(patch.Files, CleanupFile{Path: p, Keys: removed, original: content, cleaned: cleaned})
+	}
+	sort.Slice(patch.Files, func(i, k int) bool { return patch.Files[i].Path < patch.Files[k].Path })
+
+	for _, f := range patch.Files {
+		if f.Deleted {
+			patch.RemovedFiles++
+		}
+		patch.RemovedKeys += len(f.Keys)
+	}
+	diff, errs := unifiedDiff(datadir, patch.Files)
+	for _, err := range errs {
+		patch.addError(err)
+	}
+	patch.Diff = diff
+	return patch
+}
+
+// Apply writes the cleaned files and removes the deleted ones
+func (p *CleanupPatch) Apply() {
+	for _, err := range applyFiles(p.Files) {
+		p.addError(err)
+	}
+	p.Applied = true
+}
+
+// unifiedDiff returns the diff of all files relative to the datadir
+func unifiedDiff(datadir string, files []CleanupFile) (string, []error) {
+	var diff strings.Builder
+	errs := []error{}
+	for _, f := range files {
+		d, err := f.diff(datadir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		diff.WriteString(d)
+	}
+	return diff.String(), errs
+}
+
+// applyFiles writes the changed files, creates the new ones and removes the deleted ones
+func applyFiles(files []CleanupFile) []error {
+	errs := []error{}
+	for _, f := range files {
+		var err error
+		switch info, statErr := os.Stat(f.Path); {
+		case f.Deleted:
+			err = os.Remove(f.Path)
+		case os.IsNotExist(statErr):
+			if err = os.MkdirAll(filepath.Dir(f.Path), 0755); err == nil {
+				err = ioutil.WriteFile(f.Path, f.cleaned, 0644)
+			}
+		case statErr != nil:
+			err = statErr
+		default:
+			err = ioutil.WriteFile(f.Path, f.cleaned, info.Mode())
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (p *CleanupPatch) addError(err error) {
+	p.Success = false
+	p.Errors = append(p.Errors, err.Error())
+}
+
+func (f CleanupFile) diff(datadir string) (string, error) {
+	relative, err := filepath.Rel(datadir, f.Path)
+	if err != nil {
+		return "", err
+	}
+	relative = filepath.ToSlash(relative)
+	from, to := "a/"+relative, "b/"+relative
+	if f.Deleted {
+		to = "/dev/null"
+	} else if f.original == nil {
+		from = "/dev/null"
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(f.original),
+		B:        diffLines(f.cleaned),
+		FromFile: from,
+		ToFile:   to,
+		Context:  3,
+	})
+}
+
+// diffLines splits the content in lines that all end with a newline
+func diffLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
+
+func insideDir(dir string, p string) bool {
+	relative, err := filepath.Rel(dir, p)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// RemoveYamlKeys removes top level keys from a yaml document by deleting their lines, so everything else in the file
+// stays as it was. The comment lines right above a key are removed with it, except for the comments at the top of the
+// document which are kept as the header of the file. Keys that define an anchor which is still
+// used by another key are kept. It returns the new content and the keys that were removed.
+func RemoveYamlKeys(content []byte, keys []string) ([]byte, []string, []error) {
+	root, lines, blocks, err := parseYamlKeyBlocks(content)
+	if err != nil {
+		return content, nil, []error{err}
+	}
+	remove := map[string]bool{}
+	for _, k := range keys {
+		remove[k] = true
+	}
+
+	errs := []error{}
+	removed := []string{}
+	drop := map[int]bool{}
+	for i, b := range blocks {
+		if !remove[b.key.Value] {
+			continue
+		}
+		if anchor := usedAnchor(b.value, root, i*2); anchor != "" {
+			errs = append(errs, fmt.Errorf("%s is kept because it defines the anchor &%s that is still used", b.key.Value, anchor))
+			continue
+		}
+		for l := b.from; l < b.to; l++ {
+			drop[l] = true
+		}
+		removed = append(removed, b.key.Value)
+	}
+	if len(removed) == 0 {
+		return content, removed, errs
+	}
+
+	var out bytes.Buffer
+	kept := []string{}
+	for l, line := range lines {
+		if !drop[l] {
+			kept = append(kept, line)
+		}
+	}
+	// the blank lines that separated a removed last key are not needed anymore
+	for len(kept) > 0 && drop[len(lines)-1] && strings.TrimSpace(kept[len(kept)-1]) == "" {
+		kept = kept[:len(kept)-1]
+	}
+	for _, line := range kept {
+		out.WriteString(line)
+	}
+
+	var check yaml.Node
+	if err := yaml.Unmarshal(out.Bytes(), &check); err != nil {
+		return content, nil, append(errs, fmt.Errorf("the file would no longer be valid yaml: %s", err.Error()))
+	}
+	sort.Strings(removed)
+	return out.Bytes(), removed, errs
+}
+
+// yamlKeyBlock holds the lines of a top level key, from the comment lines right above it up to the next key
+type yamlKeyBlock struct {
+	key   *yaml.Node
+	value *yaml.Node
+	from  int
+	to    int
+}
+
+// parseYamlKeyBlocks splits the content in lines and returns the top level mapping with the block of every key in it
+func parseYamlKeyBlocks(content []byte) (*yaml.Node, []string, []yamlKeyBlock, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, nil, nil, err
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if len(doc.Content) == 0 {
+		return nil, lines, nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode || root.Style&yaml.FlowStyle != 0 {
+		return nil, nil, nil, fmt.Errorf("only block style mappings are supported")
+	}
+	blocks := []yamlKeyBlock{}
+	for i := 0; i < len(root.Content); i += 2 {
+		from := commentStart(lines, root.Content[i].Line-1)
+		if i == 0 && atDocumentStart(lines, from) {
+			// comments at the top of the document are about the whole file, not about the first key
+			from = root.Content[i].Line - 1
+		}
+		if len(blocks) > 0 {
+			blocks[len(blocks)-1].to = from
+		}
+		blocks = append(blocks, yamlKeyBlock{key: root.Content[i], value: root.Content[i+1], from: from})
+	}
+	if len(blocks) > 0 {
+		// a document end marker or a following document does not belong to the last key
+		last := &blocks[len(blocks)-1]
+		last.to = len(lines)
+		for i := last.from; i < len(lines); i++ {
+			if strings.HasPrefix(lines[i], "...") || strings.HasPrefix(lines[i], "---") {
+				last.to = i
+				break
+			}
+		}
+	}
+	return root, lines, blocks, nil
+}
+
+// commentStart moves up from the line of a key over the comment lines directly above it
+func commentStart(lines []string, line int) int {
+	for line > 0 && strings.HasPrefix(strings.TrimSpace(lines[line-1]), "#") {
+		line--
+	}
+	return line
+}
+
+// atDocumentStart tells if the line is the first line of the document, right after the start of the file or a --- marker
+func atDocumentStart(lines []string, line int) bool {
+	return line == 0 || strings.HasPrefix(lines[line-1], "---")
+}
+
+// usedAnchor returns an anchor defined in value that is used by an alias outside of the key at index skip of the root
+func usedAnchor(value *yaml.Node, root *yaml.Node, skip int) string {
+	anchors := map[string]bool{}
+	walkYamlNode(value, func(n *yaml.Node) {
+		if n.Anchor != "" {
+			anchors[n.Anchor] = true
+		}
+	})
+	if len(anchors) == 0 {
+		return ""
+	}
+	used := ""
+	for i := 0; i < len(root.Content); i += 2 {
+		if i == skip {
+			continue
+		}
+		walkYamlNode(root.Content[i+1], func(n *yaml.Node) {
+			if n.Kind == yaml.AliasNode && anchors[n.Value] {
+				used = n.Value
+			}
+		})
+	}
+	return used
+}
+
+func walkYamlNode(n *yaml.Node, fn func(*yaml.Node)) {
+	fn(n)
+	if n.Kind == yaml.AliasNode {
+		return
+	}
+	for _, c := range n.Content {
+		walkYamlNode(c, fn)
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRemoveYamlKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		keys    []string
+		want    string
+		removed []string
+		errs    int
+	}{
+		{
+			name:    "key with its comment",
+			content: "---\nfoo: 1\n# the servers\nntp::servers:\n  - a\nbar: 2\n",
+			keys:    []string{"ntp::servers"},
+			want:    "---\nfoo: 1\nbar: 2\n",
+			removed: []string{"ntp::servers"},
+		},
+		{
+			name:    "header comment above the first key",
+			content: "---\n# header comment for the whole file\nntp::servers:\n  - a\nfoo: 1\n",
+			keys:    []string{"ntp::servers"},
+			want:    "---\n# header comment for the whole file\nfoo: 1\n",
+			removed: []string{"ntp::servers"},
+		},
+		{
+			name:    "header comment without a document marker",
+			content: "# header\nntp::servers: a\nfoo: 1\n",
+			keys:    []string{"ntp::servers"},
+			want:    "# header\nfoo: 1\n",
+			removed: []string{"ntp::servers"},
+		},
+		{
+			name:    "header separated from the comment of the first key",
+			content: "---\n# header\n\n# the servers\nntp::servers: a\nfoo: 1\n",
+			keys:    []string{"ntp::servers"},
+			want:    "---\n# header\n\nfoo: 1\n",
+			removed: []string{"ntp::servers"},
+		},
+		{
+			name:    "last key with trailing blank lines",
+			content: "foo: 1\n\nbar: 2\n\n",
+			keys:    []string{"bar"},
+			want:    "foo: 1\n",
+			removed: []string{"bar"},
+		},
+		{
+			name:    "anchor still used",
+			content: "base: &base\n  a: 1\nbar: *base\n",
+			keys:    []string{"base"},
+			want:    "base: &base\n  a: 1\nbar: *base\n",
+			removed: []string{},
+			errs:    1,
+		},
+		{
+			name:    "key not in the file",
+			content: "foo: 1\n",
+			keys:    []string{"bar"},
+			want:    "foo: 1\n",
+			removed: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, removed, errs := RemoveYamlKeys([]byte(tt.content), tt.keys)
+			if string(out) != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+			if !reflect.DeepEqual(removed, tt.removed) {
+				t.Errorf("removed = %v, want %v", removed, tt.removed)
+			}
+			if len(errs) != tt.errs {
+				t.Errorf("errors = %v, want %d", errs, tt.errs)
+			}
+		})
+	}
+}
+
+func TestNewCleanupPatchKeepsLookupOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cleanup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "common.yaml")
+	content := "---\nlookup_options:\n  profile::users:\n    merge: deep\nprofile::users: {}\nunused: 1\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys := []YamlKeyPath{{Key: "lookup_options", Paths: []string{path}}, {Key: "unused", Paths: []string{path}}}
+	patch := NewCleanupPatch(dir, keys, []string{})
+	if len(patch.Files) != 1 {
+		t.Fatalf("got the files %+v, errors %v", patch.Files, patch.Errors)
+	}
+	want := "---\nlookup_options:\n  profile::users:\n    merge: deep\nprofile::users: {}\n"
+	if got := string(patch.Files[0].cleaned); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(patch.Files[0].Keys, []string{"unused"}) {
+		t.Errorf("removed %v", patch.Files[0].Keys)
+	}
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The exit codes of the commands
+const (
+	ExitOK     = 0
+	ExitIssues = 1
+	ExitUsage  = 2
+)
+
+// ruleDescriptions describes every lint and clean rule for the sarif output
+var ruleDescriptions = map[string]string{
+	LintSyntax:       "The file is not valid yaml or its top level is not a hash",
+	LintTabs:         "The file contains tab characters",
+	LintDuplicateKey: "A key is defined twice in the same hash",
+	LintNamespace:    "A top level key has no module namespace",
+	LintValueType:    "A key has a different type of value than in most other files",
+	LintEmptyFile:    "The file has no keys",
+	LintHierarchy:    "The file does not match any level of the hierarchy",
+	CleanUnusedFile:  "No node reads the file",
+	CleanUnusedKey:   "The key is never looked up",
+	CleanDuplicate:   "The key has the same value in several files of the hierarchy of a node",
+}
+
+// RunCommand runs the lint or clean command against a checkout without puppetdb and mongodb and returns the exit code.
+// It exits with ExitIssues when there are issues with the fail-on severity or worse.
+func RunCommand(conf Conf, args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: arvo [flags] lint|clean [command flags]")
+		return ExitUsage
+	}
+	command := args[0]
+	fs := flag.NewFlagSet("arvo "+command, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	datadir := fs.String("datadir", conf.DataDir, "The hiera data directory of the checkout")
+	hieraFile := fs.String("hiera-file", conf.HieraFile, "The hiera.yaml with the hierarchy")
+	format := fs.String("format", "text", "The output format: text, json, junit or sarif")
+	failOn := fs.String("fail-on", SeverityError, "Exit with 1 when there are issues of this severity or worse: error, warning or never")
+	only := fs.String("rules", "", "Comma separated rules to run, by default all rules that are not off")
+	var factsDir, keysFile *string
+	switch command {
+	case "lint":
+	case "clean":
+		factsDir = fs.String("facts-dir", conf.Facts.Dir, "A directory with a json or yaml facts file per node")
+		keysFile = fs.String("keys", "", "An export of the key log from v1/keys/export to find the unused keys")
+	default:
+		fmt.Fprintf(stderr, "unknown command %s, use lint or clean\n", command)
+		return ExitUsage
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return ExitUsage
+	}
+	if *failOn != SeverityError && *failOn != SeverityWarning && *failOn != "never" {
+		fmt.Fprintln(stderr, "fail-on has to be error, warning or never")
+		return ExitUsage
+	}
+	conf.DataDir = filepath.Clean(*datadir)
+	conf.HieraFile = *hieraFile
+
+	var report LintReport
+	if command == "lint" {
+		rules, err := LintRules(conf.Lint, *only)
+		if err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			return ExitUsage
+		}
+		report = LintDatadir(conf, ReadAllFilesYaml(conf), rules)
+	} else {
+		rules, err := CleanRules(conf.Lint, *only)
+		if err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			return ExitUsage
+		}
+		if *factsDir == "" {
+			fmt.Fprintln(stderr, "clean needs a -facts-dir to resolve the hierarchy of the nodes")
+			return ExitUsage
+		}
+		nodes, err := DirectoryFacts{Dir: *factsDir, Environment: conf.PuppetEnv}.All()
+		if err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			return ExitUsage
+		}
+		var logged []HieraHostDBLogEntry
+		if *keysFile != "" {
+			f, err := os.Open(*keysFile)
+			if err != nil {
+				fmt.Fprintln(stderr, err.Error())
+				return ExitUsage
+			}
+			var res ImportResult
+			logged, res, err = ParseKeyExport(f)
+			f.Close()
+			for _, e := range res.Errors {
+				fmt.Fprintln(stderr, e)
+			}
+			if err != nil {
+				fmt.Fprintln(stderr, err.Error())
+				return ExitUsage
+			}
+		}
+		report = CleanDatadir(conf, nodes, logged, rules)
+	}
+
+	if err := WriteReport(stdout, *format, "arvo "+command, conf.DataDir, report); err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return ExitUsage
+	}
+	if (*failOn == SeverityError && report.Errors > 0) || (*failOn == SeverityWarning && len(report.Issues) > 0) {
+		return ExitIssues
+	}
+	return ExitOK
+}
+
+// WriteReport writes the report in the format
+func WriteReport(w io.Writer, format string, name string, datadir string, report LintReport) error {
+	switch format {
+	case "text":
+		return writeTextReport(w, report)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "junit":
+		return writeJUnitReport(w, name, report)
+	case "sarif":
+		return writeSarifReport(w, datadir, report)
+	}
+	return fmt.Errorf("unknown format %s, use text, json, junit or sarif", format)
+}
+
+func writeTextReport(w io.Writer, report LintReport) error {
+	for _, i := range report.Issues {
+		location := i.Path
+		if i.Line > 0 {
+			location = fmt.Sprintf("%s:%d", i.Path, i.Line)
+		}
+		fmt.Fprintf(w, "%s: %s: %s [%s]\n", location, i.Severity, i.Message, i.Rule)
+	}
+	_, err := fmt.Fprintf(w, "%d errors and %d warnings in %d files\n", report.Errors, report.Warnings, report.Files)
+	return err
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport writes a test case per file with issues, the errors fail the test case and the warnings are added
+// to its output
+func writeJUnitReport(w io.Writer, name string, report LintReport) error {
+	perPath := map[string][]LintIssue{}
+	paths := []string{}
+	for _, i := range report.Issues {
+		if _, ok := perPath[i.Path]; !ok {
+			paths = append(paths, i.Path)
+		}
+		perPath[i.Path] = append(perPath[i.Path], i)
+	}
+	sort.Strings(paths)
+	suite := junitTestSuite{Name: name, Cases: []junitTestCase{}}
+	for _, p := range paths {
+		tc := junitTestCase{Name: p, Classname: name}
+		var errors, warnings []string
+		for _, i := range perPath[p] {
+			line := fmt.Sprintf("%s [%s]", i.Message, i.Rule)
+			if i.Line > 0 {
+				line = fmt.Sprintf("line %d: %s", i.Line, line)
+			}
+			if i.Severity == SeverityError {
+				errors = append(errors, line)
+			} else {
+				warnings = append(warnings, line)
+			}
+		}
+		if len(errors) > 0 {
+			tc.Failure = &junitFailure{Message: fmt.Sprintf("%d errors", len(errors)), Type: SeverityError, Text: strings.Join(errors, "\n")}
+			suite.Failures++
+		}
+		tc.SystemOut = strings.Join(warnings, "\n")
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Tests = len(suite.Cases)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeSarifReport writes the issues as a sarif 2.1.0 log. The locations are relative to the DATADIR base.
+func writeSarifReport(w io.Writer, datadir string, report LintReport) error {
+	type text struct {
+		Text string `json:"text"`
+	}
+	type rule struct {
+		ID               string `json:"id"`
+		ShortDescription text   `json:"shortDescription"`
+	}
+	type location struct {
+		PhysicalLocation map[string]interface{} `json:"physicalLocation"`
+	}
+	type result struct {
+		RuleID    string     `json:"ruleId"`
+		Level     string     `json:"level"`
+		Message   text       `json:"message"`
+		Locations []location `json:"locations"`
+	}
+
+	rules := []rule{}
+	seen := map[string]bool{}
+	results := []result{}
+	for _, i := range report.Issues {
+		if !seen[i.Rule] {
+			seen[i.Rule] = true
+			rules = append(rules, rule{ID: i.Rule, ShortDescription: text{Text: ruleDescriptions[i.Rule]}})
+		}
+		physical := map[string]interface{}{
+			"artifactLocation": map[string]string{"uri": i.Path, "uriBaseId": "DATADIR"},
+		}
+		if i.Line > 0 {
+			physical["region"] = map[string]int{"startLine": i.Line}
+		}
+		results = append(results, result{
+			RuleID:    i.Rule,
+			Level:     i.Severity,
+			Message:   text{Text: i.Message},
+			Locations: []location{{PhysicalLocation: physical}},
+		})
+	}
+	sort.Slice(rules, func(i, k int) bool { return rules[i].ID < rules[k].ID })
+
+	base, err := filepath.Abs(datadir)
+	if err != nil {
+		base = datadir
+	}
+	log := map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []interface{}{
+			map[string]interface{}{
+				"tool": map[string]interface{}{
+					"driver": map[string]interface{}{"name": "arvo", "rules": rules},
+				},
+				"originalUriBaseIds": map[string]interface{}{
+					"DATADIR": map[string]string{"uri": "file://" + filepath.ToSlash(base) + "/"},
+				},
+				"results": results,
+			},
+		},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jeremywohl/flatten"
+	"log"
+	"path/filepath"
+)
+
+// The sources the facts can be read from
+const (
+	FactsSourcePuppetDB  = "puppetdb"
+	FactsSourceDirectory = "directory"
+)
+
+// FactsConfig selects where the facts of the nodes are read from. The directory holds a json or yaml facts file per node,
+// like the yaml facts cache of puppetserver or the output of facter --json.
+type FactsConfig struct {
+	Source          string `yaml:"source"`
+	Dir             string `yaml:"dir"`
+	CacheTTLSeconds int    `yaml:"cache_ttl_seconds"`
+}
+
+// FactsProvider gives the facts of the nodes and the list of the active nodes
+type FactsProvider interface {
+	// Facts returns the flattened facts of a node, they are empty when the node is not known
+	Facts(certname string) (map[string]interface{}, error)
+	// Nodes returns the certnames of the active nodes with the time their facts were last updated
+	Nodes() (map[string]string, error)
+	// Version returns when and by whom the facts of a node were collected without fetching them, it is empty when the
+	// node is not known
+	Version(certname string) (FactsVersion, error)
+	// FactsWithVersion returns the facts of a node together with their version in one read of the facts source
+	FactsWithVersion(certname string) (map[string]interface{}, FactsVersion, error)
+}
+
+// NewFactsProvider returns the facts provider of the configured source
+func NewFactsProvider(conf Conf) (FactsProvider, error) {
+	switch conf.Facts.Source {
+	case "", FactsSourcePuppetDB:
+		return PuppetDBFacts{conf: conf}, nil
+	case FactsSourceDirectory:
+		if conf.Facts.Dir == "" {
+			return nil, errors.New("The directory facts source needs a facts dir")
+		}
+		return DirectoryFacts{Dir: conf.Facts.Dir, Environment: conf.PuppetEnv}, nil
+	}
+	return nil, fmt.Errorf("Unknown facts source %s, use %s or %s", conf.Facts.Source, FactsSourcePuppetDB, FactsSourceDirectory)
+}
+
+// PuppetDBFacts reads the facts and nodes from puppetdb
+type PuppetDBFacts struct {
+	conf Conf
+}
+
+// Facts returns the facts of the node in puppetdb
+func (p PuppetDBFacts) Facts(certname string) (map[string]interface{}, error) {
+	facts, _, err := p.FactsWithVersion(certname)
+	return facts, err
+}
+
+// FactsWithVersion reads the factset of the node, which holds the facts with their timestamp and producer
+func (p PuppetDBFacts) FactsWithVersion(certname string) (map[string]interface{}, FactsVersion, error) {
+	sets := []struct {
+		Environment string `json:"environment"`
+		Timestamp   string `json:"timestamp"`
+		Producer    string `json:"producer"`
+		Facts       struct {
+			Data []struct {
+				Name  string      `json:"name"`
+				Value interface{} `json:"value"`
+			} `json:"data"`
+		} `json:"facts"`
+	}{}
+	query := fmt.Sprintf(`["=","certname",%q]`, certname)
+	err := NewPuppetDBClient(p.conf).Get(&sets, "factsets", map[string]string{"query": query})
+	if err != nil {
+		return nil, FactsVersion{}, err
+	}
+	mapy := make(map[string]interface{})
+	if len(sets) == 0 {
+		return mapy, FactsVersion{}, nil
+	}
+	mapy["environment"] = sets[0].Environment
+	for _, fact := range sets[0].Facts.Data {
+		switch value := fact.Value.(type) {
+		case map[string]interface{}:
+			flat, err := flatten.Flatten(value, "", flatten.DotStyle)
+			if err != nil {
+				log.Println(err.Error())
+			}
+			for k, v := range flat {
+				mapy[fact.Name+"."+k] = v
+			}
+		case nil:
+			log.Println("Unknown data type was parsed in facts of this host " + certname + " fact " + fact.Name)
+		default:
+			mapy[fact.Name] = value
+		}
+	}
+	return mapy, FactsVersion{Timestamp: sets[0].Timestamp, Producer: sets[0].Producer}, nil
+}
+
+// Nodes returns the nodes that are not deactivated in puppetdb
+func (p PuppetDBFacts) Nodes() (map[string]string, error) {
+	nodes, err := NewPuppetDBClient(p.conf).Nodes()
+	if err != nil {
+		return nil, err
+	}
+	timestamps := map[string]string{}
+	for _, n := range nodes {
+		if n.Deactivated == "" && n.Certname != "" {
+			timestamps[n.Certname] = n.FactsTimestamp
+		}
+	}
+	return timestamps, nil
+}
+
+// Version returns the timestamp and producer of the factset of the node
+func (p PuppetDBFacts) Version(certname string) (FactsVersion, error) {
+	sets := []struct {
+		Timestamp string `json:"timestamp"`
+		Producer  string `json:"producer"`
+	}{}
+	query := fmt.Sprintf(`["extract",["timestamp","producer"],["=","certname",%q]]`, certname)
+	err := NewPuppetDBClient(p.conf).Get(&sets, "factsets", map[string]string{"query": query})
+	if err != nil || len(sets) == 0 {
+		return FactsVersion{}, err
+	}
+	return FactsVersion{Timestamp: sets[0].Timestamp, Producer: sets[0].Producer}, nil
+}
+
+// DirectoryFacts reads the facts from a directory with a facts file per node, every node in the directory is active.
+// Nodes without an environment fact are in the given environment.
+type DirectoryFacts struct {
+	Dir         string
+	Environment string
+}
+
+// Facts returns the facts in the file of the node
+func (d DirectoryFacts) Facts(certname string) (map[string]interface{}, error) {
+	node, err := d.node(certname)
+	if err != nil || node == nil {
+		return map[string]interface{}{}, err
+	}
+	return d.withEnvironment(node.Facts), nil
+}
+
+// FactsWithVersion returns the facts and the timestamp in the file of the node
+func (d DirectoryFacts) FactsWithVersion(certname string) (map[string]interface{}, FactsVersion, error) {
+	node, err := d.node(certname)
+	if err != nil || node == nil {
+		return map[string]interface{}{}, FactsVersion{}, err
+	}
+	return d.withEnvironment(node.Facts), FactsVersion{Timestamp: node.Timestamp}, nil
+}
+
+// Version returns the timestamp in the file of the node, or else the time the file was changed
+func (d DirectoryFacts) Version(certname string) (FactsVersion, error) {
+	node, err := d.node(certname)
+	if err != nil || node == nil {
+		return FactsVersion{}, err
+	}
+	return FactsVersion{Timestamp: node.Timestamp}, nil
+}
+
+// node reads the facts file of the node, it is nil when there is none
+func (d DirectoryFacts) node(certname string) (*factsNode, error) {
+	for _, ext := range factsFileExtensions {
+		node, err := readFactsNode(filepath.Join(d.Dir, certname+ext))
+		if err == nil && node.Certname == certname {
+			return &node, nil
+		}
+	}
+	// puppet facts files can be named differently than the node in them
+	path, err := factsDirIndexFor(d.Dir).find(d.Dir, certname)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	node, err := readFactsNode(path)
+	if err != nil || node.Certname != certname {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Nodes returns the certname of every facts file with the time the facts were collected, or else the time the file
+// was changed
+func (d DirectoryFacts) Nodes() (map[string]string, error) {
+	nodes, err := readFactsNodes(d.Dir)
+	if err != nil {
+		return nil, err
+	}
+	timestamps := map[string]string{}
+	for _, node := range nodes {
+		timestamps[node.Certname] = node.Timestamp
+	}
+	return timestamps, nil
+}
+
+// All returns the facts of every node in the directory
+func (d DirectoryFacts) All() (map[string]map[string]interface{}, error) {
+	nodes, err := readFactsNodes(d.Dir)
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]map[string]interface{}{}
+	for _, node := range nodes {
+		all[node.Certname] = d.withEnvironment(node.Facts)
+	}
+	return all, nil
+}
+
+func (d DirectoryFacts) withEnvironment(facts map[string]interface{}) map[string]interface{} {
+	if _, ok := facts["environment"]; !ok && d.Environment != "" {
+		facts["environment"] = d.Environment
+	}
+	return facts
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FactsVersion identifies the facts of a node that were collected at the same time by the same producer
+type FactsVersion struct {
+	Timestamp string
+	Producer  string
+}
+
+// FactsCacheStats shows how well the facts cache works. Revalidated entries had expired but their facts did not change
+// in the facts source, they count as hits.
+type FactsCacheStats struct {
+	Entries     int     `json:"entries"`
+	TTLSeconds  int     `json:"ttl_seconds"`
+	Hits        uint64  `json:"hits"`
+	Revalidated uint64  `json:"revalidated"`
+	Misses      uint64  `json:"misses"`
+	Invalidated uint64  `json:"invalidated"`
+	Flushed     uint64  `json:"flushed"`
+	HitRate     float64 `json:"hit_rate"`
+}
+
+// FactsCache keeps the flattened facts of the nodes in memory. An entry is used until its ttl passed, after that the
+// version of the facts is checked once and the facts are only fetched again when their timestamp or producer changed
+// in the facts source. A node that is not cached is fetched with its version in one read.
+type FactsCache struct {
+	mu          sync.RWMutex
+	ttl         time.Duration
+	entries     map[string]cachedFacts
+	hits        uint64
+	revalidated uint64
+	misses      uint64
+	invalidated uint64
+	flushed     uint64
+}
+
+type cachedFacts struct {
+	facts   map[string]interface{}
+	version FactsVersion
+	fetched time.Time
+}
+
+// factsCache is shared by every endpoint and job so the facts of a node are fetched once per ttl
+var factsCache = NewFactsCache(0)
+
+// NewFactsCache creates an empty cache, with a ttl of 0 nothing is cached
+func NewFactsCache(ttl time.Duration) *FactsCache {
+	return &FactsCache{ttl: ttl, entries: map[string]cachedFacts{}}
+}
+
+// SetFactsCacheTTL sets how long the shared facts cache uses the facts of a node without checking the facts source
+func SetFactsCacheTTL(ttl time.Duration) {
+	factsCache.mu.Lock()
+	factsCache.ttl = ttl
+	factsCache.mu.Unlock()
+}
+
+// Get returns the facts of the node from the cache or else from the provider. Nodes without facts are not cached.
+func (c *FactsCache) Get(provider FactsProvider, certname string) (map[string]interface{}, error) {
+	c.mu.RLock()
+	ttl := c.ttl
+	cached, ok := c.entries[certname]
+	c.mu.RUnlock()
+	if ttl <= 0 {
+		return provider.Facts(certname)
+	}
+	if ok && time.Since(cached.fetched) < ttl {
+		atomic.AddUint64(&c.hits, 1)
+		return copyFacts(cached.facts), nil
+	}
+
+	if ok {
+		version, err := provider.Version(certname)
+		if err == nil && version.Timestamp != "" && version == cached.version {
+			atomic.AddUint64(&c.revalidated, 1)
+			c.mu.Lock()
+			cached.fetched = time.Now()
+			c.entries[certname] = cached
+			c.mu.Unlock()
+			return copyFacts(cached.facts), nil
+		}
+	}
+
+	atomic.AddUint64(&c.misses, 1)
+	facts, version, err := provider.FactsWithVersion(certname)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if len(facts) == 0 {
+		delete(c.entries, certname)
+	} else {
+		c.entries[certname] = cachedFacts{facts: copyFacts(facts), version: version, fetched: time.Now()}
+	}
+	c.mu.Unlock()
+	return facts, nil
+}
+
+// Invalidate drops the cached nodes whose facts timestamp is not the one in timestamps, like the facts timestamps of
+// every node that a refresh reads anyway. Nodes that are not in timestamps are dropped too.
+func (c *FactsCache) Invalidate(timestamps map[string]string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := 0
+	for certname, cached := range c.entries {
+		if timestamp, ok := timestamps[certname]; !ok || timestamp != cached.version.Timestamp {
+			delete(c.entries, certname)
+			count++
+		}
+	}
+	atomic.AddUint64(&c.invalidated, uint64(count))
+	return count
+}
+
+// Flush drops the cached facts of the node, or of every node when certname is empty
+func (c *FactsCache) Flush(certname string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := len(c.entries)
+	if certname == "" {
+		c.entries = map[string]cachedFacts{}
+	} else if _, ok := c.entries[certname]; ok {
+		delete(c.entries, certname)
+		count = 1
+	} else {
+		count = 0
+	}
+	atomic.AddUint64(&c.flushed, uint64(count))
+	return count
+}
+
+// Stats returns the counters of the cache
+func (c *FactsCache) Stats() FactsCacheStats {
+	c.mu.RLock()
+	stats := FactsCacheStats{Entries: len(c.entries), TTLSeconds: int(c.ttl / time.Second)}
+	c.mu.RUnlock()
+	stats.Hits = atomic.LoadUint64(&c.hits)
+	stats.Revalidated = atomic.LoadUint64(&c.revalidated)
+	stats.Misses = atomic.LoadUint64(&c.misses)
+	stats.Invalidated = atomic.LoadUint64(&c.invalidated)
+	stats.Flushed = atomic.LoadUint64(&c.flushed)
+	if total := stats.Hits + stats.Revalidated + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits+stats.Revalidated) / float64(total)
+	}
+	return stats
+}
+
+// FlushFactsCacheEndpoint example
+// @Summary Flush the facts cache
+// @Description Drops the cached facts of every node, or only of the node given as certname, so they are fetched again from the facts source.
+// @Param  certname  query  string  false  "Only flush the facts of this node"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} APIMessage
+// @Router /facts/cache [delete]
+func FlushFactsCacheEndpoint() gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		count := factsCache.Flush(c.Query("certname"))
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": fmt.Sprintf("Flushed the facts of %d nodes", count)})
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// copyFacts copies the map so callers can change the facts without changing the cache
+func copyFacts(facts map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(facts))
+	for k, v := range facts {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"fmt"
+	"github.com/jeremywohl/flatten"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// factsFileExtensions are the extensions of the files read from a facts directory
+var factsFileExtensions = []string{".yaml", ".json", ".yml"}
+
+// factsNode holds the facts of a node read from a facts file
+type factsNode struct {
+	Certname  string
+	Facts     map[string]interface{}
+	Timestamp string
+}
+
+// readFactsNodes reads every json or yaml facts file in the directory, the certname is the name in the file or else
+// the file name without its extension. Files that can not be read are logged and skipped so one broken file does not
+// hide every other node.
+func readFactsNodes(dir string) ([]factsNode, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []factsNode{}
+	for _, f := range files {
+		if f.IsDir() || !isFactsFile(f.Name()) {
+			continue
+		}
+		node, err := readFactsNode(filepath.Join(dir, f.Name()))
+		if err != nil {
+			log.Println("Skipping facts file " + err.Error())
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// factsDirIndex remembers which file of a facts directory holds which certname, so a node whose file is named
+// differently is found without reading the whole directory again. Only new and changed files are read.
+type factsDirIndex struct {
+	mu    sync.Mutex
+	files map[string]factsFileEntry
+}
+
+type factsFileEntry struct {
+	modTime  time.Time
+	size     int64
+	certname string
+}
+
+// factsDirIndexes holds the index of every facts directory that was searched
+var factsDirIndexes = struct {
+	sync.Mutex
+	dirs map[string]*factsDirIndex
+}{dirs: map[string]*factsDirIndex{}}
+
+func factsDirIndexFor(dir string) *factsDirIndex {
+	factsDirIndexes.Lock()
+	defer factsDirIndexes.Unlock()
+	index, ok := factsDirIndexes.dirs[dir]
+	if !ok {
+		index = &factsDirIndex{files: map[string]factsFileEntry{}}
+		factsDirIndexes.dirs[dir] = index
+	}
+	return index
+}
+
+// find returns the path of the facts file of the certname, it is empty when no file in the directory holds the node
+func (x *factsDirIndex) find(dir string, certname string) (string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	found := ""
+	present := map[string]bool{}
+	for _, f := range files {
+		if f.IsDir() || !isFactsFile(f.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		present[path] = true
+		entry, ok := x.files[path]
+		if !ok || !entry.modTime.Equal(f.ModTime()) || entry.size != f.Size() {
+			entry = factsFileEntry{modTime: f.ModTime(), size: f.Size()}
+			node, err := readFactsNode(path)
+			if err != nil {
+				log.Println("Skipping facts file " + err.Error())
+			} else {
+				entry.certname = node.Certname
+			}
+			x.files[path] = entry
+		}
+		if found == "" && entry.certname == certname {
+			found = path
+		}
+	}
+	for path := range x.files {
+		if !present[path] {
+			delete(x.files, path)
+		}
+	}
+	return found, nil
+}
+
+func isFactsFile(name string) bool {
+	for _, ext := range factsFileExtensions {
+		if filepath.Ext(name) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func readFactsNode(path string) (factsNode, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return factsNode{}, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return factsNode{}, err
+	}
+	// puppet facts --render-as yaml tags the document with the ruby class
+	if strings.HasPrefix(string(content), "--- !ruby/object") {
+		content = content[strings.Index(string(content), "\n")+1:]
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return factsNode{}, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	node := factsNode{
+		Certname:  strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Timestamp: info.ModTime().UTC().Format(time.RFC3339Nano),
+	}
+	// puppet facts and the facts cache of puppetserver wrap the facts with the name of the node and the time they
+	// were collected
+	if nested, ok := values["values"].(map[string]interface{}); ok {
+		if name, ok := values["name"].(string); ok && name != "" {
+			node.Certname = name
+		}
+		if timestamp, ok := values["timestamp"]; ok {
+			node.Timestamp = fmt.Sprint(timestamp)
+		}
+		values = nested
+	}
+	node.Facts = FlattenFacts(node.Certname, values)
+	return node, nil
+}
+
+// FlattenFacts flattens structured facts to names with dots like trusted.certname. When the facts have no trusted
+// facts the certname is added as trusted.certname.
+func FlattenFacts(certname string, values map[string]interface{}) map[string]interface{} {
+	facts := map[string]interface{}{}
+	for name, value := range values {
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			facts[name] = value
+			continue
+		}
+		flat, err := flatten.Flatten(nested, "", flatten.DotStyle)
+		if err != nil {
+			continue
+		}
+		for k, v := range flat {
+			facts[name+"."+k] = v
+		}
+	}
+	if _, ok := facts["trusted.certname"]; !ok {
+		facts["trusted.certname"] = certname
+	}
+	return facts
+}
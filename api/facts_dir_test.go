@@ -0,0 +1,52 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectoryFacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "facts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"web01.example.com.json": "{\"os\": {\"family\": \"Debian\"}}\n",
+		"cached.yaml":            "--- !ruby/object:Puppet::Node::Facts\nname: db01.example.com\nvalues:\n  role: db\ntimestamp: \"2020-05-11T10:02:13Z\"\n",
+		"broken.yaml":            "role: [db\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := DirectoryFacts{Dir: dir, Environment: "production"}
+
+	nodes, err := d.Nodes()
+	if err != nil {
+		t.Fatalf("a broken file should be skipped: %s", err)
+	}
+	if len(nodes) != 2 || nodes["db01.example.com"] != "2020-05-11T10:02:13Z" {
+		t.Errorf("got the nodes %v", nodes)
+	}
+	all, err := d.All()
+	if err != nil || len(all) != 2 {
+		t.Errorf("got %v, %v", all, err)
+	}
+
+	facts, err := d.Facts("db01.example.com")
+	if err != nil || facts["role"] != "db" || facts["environment"] != "production" {
+		t.Errorf("facts of a node in a differently named file: %v, %v", facts, err)
+	}
+	facts, err = d.Facts("web01.example.com")
+	if err != nil || facts["os.family"] != "Debian" {
+		t.Errorf("facts of a node in its own file: %v, %v", facts, err)
+	}
+	facts, err = d.Facts("unknown.example.com")
+	if err != nil || len(facts) != 0 {
+		t.Errorf("facts of an unknown node: %v, %v", facts, err)
+	}
+}
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitConfig configures the commits arvo makes in the git checkout of the datadir. Without an author name and email
+// the identity from the git config of the checkout is used.
+type GitConfig struct {
+	AuthorName   string `yaml:"author_name"`
+	AuthorEmail  string `yaml:"author_email"`
+	BranchPrefix string `yaml:"branch_prefix"`
+}
+
+// GitChange is the commit that last changed a file or the lines of a key
+type GitChange struct {
+	Commit  string    `json:"commit"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Summary string    `json:"summary"`
+}
+
+// KeyBlame is the last change to an unused key in one of the files it is defined in
+type KeyBlame struct {
+	Key        string     `json:"key"`
+	Path       string     `json:"path"`
+	LastChange *GitChange `json:"last_change"`
+}
+
+// FileBlame is the last change to an unused file
+type FileBlame struct {
+	Path       string     `json:"path"`
+	LastChange *GitChange `json:"last_change"`
+}
+
+// GitBlameReport shows who last changed the unused keys and files of the clean all result
+type GitBlameReport struct {
+	Keys   []KeyBlame  `json:"keys"`
+	Files  []FileBlame `json:"files"`
+	Errors []string    `json:"errors"`
+}
+
+// GitCleanupBranch is the branch with the cleanup commit
+type GitCleanupBranch struct {
+	Success      bool          `json:"success"`
+	Branch       string        `json:"branch"`
+	Commit       string        `json:"commit"`
+	Base         string        `json:"base"`
+	RemovedKeys  int           `json:"removed_keys"`
+	RemovedFiles int           `json:"removed_files"`
+	Files        []CleanupFile `json:"files"`
+	Errors       []string      `json:"errors"`
+}
+
+// GitBlameEndpoint example
+// @Summary Get the last change to every unused key and file
+// @Description Reads the git history of the datadir to show who last changed the unused keys and files of the clean all result and when. Ignored keys and paths are left out. Lines that are not committed yet show an empty commit.
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} GitBlameReport
+// @Failure 404 {object} APIMessage "No clean all result was found"
+// @Failure 500 {object} APIMessage "The datadir is not a git checkout"
+// @Router /git/blame [get]
+func GitBlameEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		full, err := GetFullCleanResultEntry(conf.DB)
+		if err == ErrEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": NoCleanAllResultMessage})
+			return
+		}
+		if full == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		res := GetIgnoreMatcher(conf).FilterCleanAllResult(*full)
+		report, err := GetGitBlameReport(conf.DataDir, res)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// GitCleanupBranchEndpoint example
+// @Summary Create a local branch with a cleanup commit
+// @Description Commits the cleanup patch of the clean all result on a new branch on top of HEAD of the datadir checkout. The working tree, the index and the current branch are not touched, so the branch can be reviewed and pushed by hand. Files with uncommitted changes are left out.
+// @Param  branch query  string     false "The name of the branch, by default the branch_prefix with the current time"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} GitCleanupBranch
+// @Failure 400 {object} APIMessage "The branch name is not valid"
+// @Failure 404 {object} APIMessage "No clean all result was found"
+// @Failure 409 {object} APIMessage "The branch already exists"
+// @Failure 500 {object} APIMessage "Something went wrong creating the branch"
+// @Router /git/cleanup-branch [post]
+func GitCleanupBranchEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		branch := c.Query("branch")
+		if branch == "" {
+			branch = conf.Git.BranchPrefix + time.Now().Format("20060102-150405")
+		}
+		if err := CheckGitBranchName(conf.DataDir, branch); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		if GitBranchExists(conf.DataDir, branch) {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "message": fmt.Sprintf("The branch %s already exists", branch)})
+			return
+		}
+		patch, err := CreateCleanupPatch(conf)
+		if err == ErrEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": NoCleanAllResultMessage})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		res, err := CreateCleanupBranch(conf, patch, branch)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// runGit runs git in dir and returns its output. Extra environment variables are added to the environment of arvo.
+func runGit(dir string, env []string, stdin []byte, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return string(out), nil
+}
+
+// CheckGitBranchName returns an error when git does not accept the name as the name of a branch
+func CheckGitBranchName(dir string, branch string) error {
+	if strings.HasPrefix(branch, "-") {
+		return fmt.Errorf("%s is not a valid branch name", branch)
+	}
+	if _, err := runGit(dir, nil, nil, "check-ref-format", "--branch", branch); err != nil {
+		return fmt.Errorf("%s is not a valid branch name", branch)
+	}
+	return nil
+}
+
+// GitBranchExists returns if the checkout of dir has a local branch with this name
+func GitBranchExists(dir string, branch string) bool {
+	_, err := runGit(dir, nil, nil, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch)
+	return err == nil
+}
+
+// GetGitBlameReport blames every file of the unused keys once and uses the lines of the key, with the comments right
+// above it, to find the last change to the key
+func GetGitBlameReport(datadir string, res CleanAllResult) (*GitBlameReport, error) {
+	if _, err := runGit(datadir, nil, nil, "rev-parse", "--git-dir"); err != nil {
+		return nil, err
+	}
+	report := &GitBlameReport{Keys: []KeyBlame{}, Files: []FileBlame{}, Errors: []string{}}
+	keysPerPath := map[string][]string{}
+	for _, k := range res.KeysNeverUsed {
+		for _, p := range k.Paths {
+			keysPerPath[p] = append(keysPerPath[p], k.Key)
+		}
+	}
+	for p, keys := range keysPerPath {
+		blame, err := gitBlame(p)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		_, _, blocks, err := parseYamlKeyBlocks(content)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", p, err.Error()))
+			continue
+		}
+		for _, b := range blocks {
+			if !stringInSlice(b.key.Value, keys) {
+				continue
+			}
+			kb := KeyBlame{Key: b.key.Value, Path: p}
+			for l := b.from; l < b.to && l < len(blame); l++ {
+				if kb.LastChange == nil || blame[l].Date.After(kb.LastChange.Date) {
+					kb.LastChange = blame[l]
+				}
+			}
+			report.Keys = append(report.Keys, kb)
+		}
+	}
+	sort.Slice(report.Keys, func(i, k int) bool {
+		if report.Keys[i].Key == report.Keys[k].Key {
+			return report.Keys[i].Path < report.Keys[k].Path
+		}
+		return report.Keys[i].Key < report.Keys[k].Key
+	})
+
+	for _, p := range res.PathsNeverUsed {
+		change, err := gitLastChange(p)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		report.Files = append(report.Files, FileBlame{Path: p, LastChange: change})
+	}
+	return report, nil
+}
+
+// gitBlame returns the last change to every line of the file, lines that are not committed have an empty commit
+func gitBlame(p string) ([]*GitChange, error) {
+	out, err := runGit(filepath.Dir(p), nil, nil, "blame", "--line-porcelain", "--", filepath.Base(p))
+	if err != nil {
+		return nil, err
+	}
+	lines := []*GitChange{}
+	var change *GitChange
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			lines = append(lines, change)
+			change = nil
+		case change == nil:
+			fields := strings.Fields(line)
+			change = &GitChange{}
+			if len(fields) > 0 && strings.Trim(fields[0], "0") != "" {
+				change.Commit = fields[0]
+			}
+		case strings.HasPrefix(line, "author "):
+			change.Author = strings.TrimPrefix(line, "author ")
+		case strings.HasPrefix(line, "author-mail "):
+			change.Email = strings.Trim(strings.TrimPrefix(line, "author-mail "), "<>")
+		case strings.HasPrefix(line, "author-time "):
+			sec, _ := strconv.ParseInt(strings.TrimPrefix(line, "author-time "), 10, 64)
+			change.Date = time.Unix(sec, 0).UTC()
+		case strings.HasPrefix(line, "summary "):
+			change.Summary = strings.TrimPrefix(line, "summary ")
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// gitLastChange returns the last commit that changed the file or nil when it was never committed
+func gitLastChange(p string) (*GitChange, error) {
+	out, err := runGit(filepath.Dir(p), nil, nil, "log", "-1", "--format=%H%x00%an%x00%ae%x00%at%x00%s", "--", filepath.Base(p))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(strings.TrimSpace(out), "\x00", 5)
+	if len(fields) < 5 {
+		return nil, nil
+	}
+	sec, _ := strconv.ParseInt(fields[3], 10, 64)
+	return &GitChange{
+		Commit:  fields[0],
+		Author:  fields[1],
+		Email:   fields[2],
+		Date:    time.Unix(sec, 0).UTC(),
+		Summary: fields[4],
+	}, nil
+}
+
+// CreateCleanupBranch commits the patch on a new branch on top of HEAD. The commit is built with a temporary index so
+// the working tree, the index and the checked out branch stay as they are.
+func CreateCleanupBranch(conf Conf, patch *CleanupPatch, branch string) (*GitCleanupBranch, error) {
+	datadir := conf.DataDir
+	if err := CheckGitBranchName(datadir, branch); err != nil {
+		return nil, err
+	}
+	prefix, err := runGit(datadir, nil, nil, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.TrimSpace(prefix)
+	// the index is changed from the top of the checkout so every path is relative to it
+	top, err := runGit(datadir, nil, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top = strings.TrimSpace(top)
+	base, err := runGit(top, nil, nil, "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	base = strings.TrimSpace(base)
+	// the patch is made from the working tree, files that differ from HEAD would commit more than the cleanup
+	// -z gives the paths as they are instead of quoting the ones with special characters
+	status, err := runGit(top, nil, nil, "status", "--porcelain", "-z", "--no-renames", "--", prefix+".")
+	if err != nil {
+		return nil, err
+	}
+	dirty := map[string]bool{}
+	for _, entry := range strings.Split(status, "\x00") {
+		if len(entry) > 3 {
+			dirty[entry[3:]] = true
+		}
+	}
+
+	res := &GitCleanupBranch{Success: patch.Success, Branch: branch, Base: base, Files: []CleanupFile{}, Errors: patch.Errors}
+	tmp, err := ioutil.TempDir("", "arvo-git")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(tmp, "index")}
+	if conf.Git.AuthorName != "" {
+		env = append(env, "GIT_AUTHOR_NAME="+conf.Git.AuthorName, "GIT_COMMITTER_NAME="+conf.Git.AuthorName)
+	}
+	if conf.Git.AuthorEmail != "" {
+		env = append(env, "GIT_AUTHOR_EMAIL="+conf.Git.AuthorEmail, "GIT_COMMITTER_EMAIL="+conf.Git.AuthorEmail)
+	}
+	if _, err := runGit(top, env, nil, "read-tree", "HEAD"); err != nil {
+		return nil, err
+	}
+
+	message := []string{}
+	for _, f := range patch.Files {
+		relative, err := filepath.Rel(datadir, f.Path)
+		if err != nil {
+			res.Errors = append(res.Errors, err.Error())
+			continue
+		}
+		name := prefix + filepath.ToSlash(relative)
+		if dirty[name] {
+			res.Errors = append(res.Errors, fmt.Sprintf("%s has uncommitted changes and is left out", f.Path))
+			continue
+		}
+		if f.Deleted {
+			_, err = runGit(top, env, nil, "update-index", "--force-remove", "--", name)
+		} else {
+			err = gitStageContent(top, env, name, f)
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, err.Error())
+			continue
+		}
+		if f.Deleted {
+			res.RemovedFiles++
+			message = append(message, fmt.Sprintf("- %s", relative))
+		} else {
+			message = append(message, fmt.Sprintf("- %s: %s", relative, strings.Join(f.Keys, ", ")))
+		}
+		res.RemovedKeys += len(f.Keys)
+		res.Files = append(res.Files, f)
+	}
+	if len(res.Files) == 0 {
+		return nil, fmt.Errorf("There is nothing to clean up")
+	}
+
+	tree, err := runGit(top, env, nil, "write-tree")
+	if err != nil {
+		return nil, err
+	}
+	msg := fmt.Sprintf("Remove unused hiera data\n\nKeys removed: %d\nFiles removed: %d\n\n%s\n",
+		res.RemovedKeys, res.RemovedFiles, strings.Join(message, "\n"))
+	commit, err := runGit(top, env, []byte(msg), "commit-tree", strings.TrimSpace(tree), "-p", base)
+	if err != nil {
+		return nil, err
+	}
+	res.Commit = strings.TrimSpace(commit)
+	// an empty old value makes sure an existing branch is never overwritten
+	if _, err := runGit(top, nil, nil, "update-ref", "refs/heads/"+branch, res.Commit, ""); err != nil {
+		return nil, err
+	}
+	res.Success = len(res.Errors) == 0
+	return res, nil
+}
+
+// gitStageContent writes the cleaned content of the file to the object database and adds it to the temporary index
+func gitStageContent(top string, env []string, name string, f CleanupFile) error {
+	sha, err := runGit(top, env, f.cleaned, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return err
+	}
+	mode := "100644"
+	if info, err := os.Stat(f.Path); err == nil && info.Mode()&0111 != 0 {
+		mode = "100755"
+	}
+	_, err = runGit(top, env, nil, "update-index", "--add", "--cacheinfo", mode+","+strings.TrimSpace(sha)+","+name)
+	return err
+}
@@ -1,15 +1,19 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -35,6 +39,8 @@ func parseArray(anArray []interface{}, factTotal *bson.M, factName string) {
 // @Summary Get all logged keys for one entry
 // @Description Shows you all the logged hiera keys for one host
 // @Param  id     path   string     true  "Some ID"
+// @Param  since  query  string     false "Only keys looked up after this time (RFC3339, date or duration like 30d)"
+// @Param  until  query  string     false "Only keys looked up before this time (RFC3339, date or duration like 30d)"
 // @Accept  json
 // @Produce  json
 // @Success 200 {object} HieraHostDBEntry
@@ -46,7 +52,12 @@ func GetKeysForOneCertnamesEndpoint(d Conf) gin.HandlerFunc {
 		c.ShouldBindUri(&u1)
 		defer c.Done()
 
-		s, err := GetOneCertnameLogEntry(d.DB, u1.ID)
+		w, err := GetTimeWindowFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		s, err := GetOneCertnameLogEntry(d.DB, u1.ID, w)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
 
@@ -60,6 +71,8 @@ func GetKeysForOneCertnamesEndpoint(d Conf) gin.HandlerFunc {
 // GetKeysForAllCertnamesEndpoint example
 // @Summary Get all logged keys for all hosts
 // @Description Shows you all the logged hiera keys from all the hosts that logged keys.
+// @Param  since  query  string     false "Only keys looked up after this time (RFC3339, date or duration like 30d)"
+// @Param  until  query  string     false "Only keys looked up before this time (RFC3339, date or duration like 30d)"
 // @Accept  json
 // @Produce  json
 // @Success 200 {object} []HieraHostDBEntry
@@ -67,7 +80,12 @@ func GetKeysForOneCertnamesEndpoint(d Conf) gin.HandlerFunc {
 // @Router /keys [get]
 func GetKeysForAllCertnamesEndpoint(conf Conf) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		hosts, err := GetAllCertnameLogEntry(conf.DB)
+		w, err := GetTimeWindowFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		hosts, err := GetAllCertnameLogEntry(conf.DB, w)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
 
@@ -81,14 +99,16 @@ func GetKeysForAllCertnamesEndpoint(conf Conf) gin.HandlerFunc {
 
 // PostKeyEndpoint example
 // @Summary Log a looked up hiera key
-// @Description This logs a hiera key from puppet. Older log entries will be deleted.
+// @Description This logs a hiera key from puppet. The key is queued and written to the database in the background. Entries that were not looked up within key_retention_days are removed by the database.
 // @Accept  json
 // @Produce  json
 // @Param   log      body HieraHostDBLogEntry true  "Log"
-// @Success 200 {object} APIMessage	"Inserted one entries"
+// @Success 202 {object} APIMessage	"The key was queued"
+// @Failure 400 {object} APIMessage "Certname or key is missing"
+// @Failure 429 {object} APIMessage "The queue is full try again after the Retry-After header"
 // @Failure 500 {object} APIMessage "Something went wrong creating the log entry"
 // @Router /keys [post]
-func PostKeyEndpoint(conf Conf) gin.HandlerFunc {
+func PostKeyEndpoint(conf Conf, ingester *KeyIngester, stream *KeyStream) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		var u HieraHostDBLogEntry
 		err := c.BindJSON(&u)
@@ -97,24 +117,19 @@ func PostKeyEndpoint(conf Conf) gin.HandlerFunc {
 		if err != nil {
 			log.Println(err.Error())
 			c.JSON(http.StatusInternalServerError, gin.H{"inserted": false, "message": err.Error()})
+		} else if u.Certname == "" || u.Key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"inserted": false, "message": "Both certname and key need to be given"})
 		} else {
-			currentTime := time.Now()
-			str := currentTime.Format(LAYOUT)
-			e := HieraHostDBEntry{
-				ID: u.Certname,
-				Entries: []HieraHostDBLogEntry{
-					{
-						Certname: u.Certname,
-						Key:      u.Key,
-						Date:     &str,
-					},
-				},
+			if u.Date == nil {
+				str := time.Now().Format(LAYOUT)
+				u.Date = &str
 			}
-			res, err := InsertLogEntryWrapper(e, conf)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			if ingester.Enqueue(u) {
+				stream.Publish(u)
+				c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Queued one entry"})
 			} else {
-				c.JSON(http.StatusCreated, gin.H{"success": true, "message": *res})
+				c.Header("Retry-After", strconv.Itoa(conf.Ingest.RetryAfterSeconds))
+				c.JSON(http.StatusTooManyRequests, gin.H{"success": false, "message": "The ingest queue is full"})
 			}
 
 		}
@@ -122,141 +137,494 @@ func PostKeyEndpoint(conf Conf) gin.HandlerFunc {
 	return gin.HandlerFunc(fn)
 }
 
-func GetAllCertnameLogEntry(d Database) ([]HieraHostDBEntry, error) {
-	dbConn, err := NewClient(d)
+// PostKeyBatchEndpoint example
+// @Summary Log a batch of looked up hiera keys
+// @Description Logs many hiera lookups in one request. The body is either a json array or newline delimited json (application/x-ndjson). The lookups may be for one or many certnames. They are queued like the lookups posted one by one and written to the database in the background.
+// @Accept  json
+// @Produce  json
+// @Param   log      body []HieraHostDBLogEntry true  "Logs"
+// @Success 202 {object} KeyBatchResult "The result for every posted lookup"
+// @Failure 400 {object} APIMessage "The body could not be read"
+// @Failure 429 {object} KeyBatchResult "Some lookups did not fit in the queue, post the failed ones again after the Retry-After header"
+// @Router /keys/batch [post]
+func PostKeyBatchEndpoint(conf Conf, ingester *KeyIngester, stream *KeyStream) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxKeyBatchBytes))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("%s, a batch may be at most %d bytes", err.Error(), MaxKeyBatchBytes)})
+			return
+		}
+		entries, results, err := ParseKeyBatch(body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		if len(results) > MaxKeyBatchSize {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("A batch may contain at most %d lookups", MaxKeyBatchSize)})
+			return
+		}
+
+		// only the valid lookups are queued, the ones that do not fit in the queue can be posted again
+		full := false
+		for i, e := range entries {
+			if results[i].Message != "" {
+				continue
+			}
+			if !ingester.Enqueue(e) {
+				full = true
+				results[i].Message = "The ingest queue is full"
+				continue
+			}
+			results[i].Success = true
+			stream.Publish(e)
+		}
+
+		res := KeyBatchResult{Success: true, Results: results}
+		for _, r := range results {
+			if r.Success {
+				res.Accepted++
+			} else {
+				res.Failed++
+				res.Success = false
+			}
+		}
+		if full {
+			c.Header("Retry-After", strconv.Itoa(conf.Ingest.RetryAfterSeconds))
+			c.JSON(http.StatusTooManyRequests, res)
+			return
+		}
+		c.JSON(http.StatusAccepted, res)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// ParseKeyBatch reads a json array or newline delimited json into log entries. For every entry there is a result
+// at the same index, the message of that result is set when the entry can not be logged. It returns an error when the
+// newline delimited json can not be read, like a line that is too long, so no lines are silently dropped.
+func ParseKeyBatch(body []byte) ([]HieraHostDBLogEntry, []KeyBatchItemResult, error) {
+	raw := []json.RawMessage{}
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		err := json.Unmarshal(trimmed, &raw)
+		if err != nil {
+			return []HieraHostDBLogEntry{{}}, []KeyBatchItemResult{{Index: 0, Message: err.Error()}}, nil
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		lines := 0
+		for scanner.Scan() {
+			lines++
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) > 0 {
+				raw = append(raw, json.RawMessage(append([]byte{}, line...)))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, fmt.Errorf("line %d could not be read: %s", lines+1, err.Error())
+		}
+	}
+
+	now := time.Now().Format(LAYOUT)
+	entries := []HieraHostDBLogEntry{}
+	results := []KeyBatchItemResult{}
+	for i, r := range raw {
+		var e HieraHostDBLogEntry
+		result := KeyBatchItemResult{Index: i}
+		err := json.Unmarshal(r, &e)
+		if err != nil {
+			result.Message = err.Error()
+		} else if e.Certname == "" || e.Key == "" {
+			result.Message = "Both certname and key need to be given"
+		}
+		if e.Date == nil {
+			str := now
+			e.Date = &str
+		}
+		result.Certname = e.Certname
+		result.Key = e.Key
+		entries = append(entries, e)
+		results = append(results, result)
+	}
+	return entries, results, nil
+}
+
+// GetAllCertnameLogEntry collects the logged keys of every certname that were looked up inside the window and groups them per certname.
+func GetAllCertnameLogEntry(d Database, w TimeWindow) ([]HieraHostDBEntry, error) {
 	arr := []HieraHostDBEntry{}
+	records, err := findKeyLogRecords(d, w.filter())
 	if err != nil {
 		return arr, err
 	}
-	collection := dbConn.Database(d.Database).Collection("logging")
-	findOptions := options.Find()
-	filter := bson.D{}
-	// Finding multiple documents returns a cursor
-	cur, err := collection.Find(context.TODO(), filter, findOptions)
-	for cur.Next(context.TODO()) {
-		var elem HieraHostDBEntry
+	return groupKeyLogRecords(records), nil
+}
 
-		err := cur.Decode(&elem)
-		if err != nil {
-			log.Println(err.Error())
-		} else {
-			arr = append(arr, elem)
-		}
+// GetOneCertnameLogEntry collects all the logged keys for one certname that were looked up inside the window.
+func GetOneCertnameLogEntry(d Database, certname string, w TimeWindow) (*HieraHostDBEntry, error) {
+	filter := w.filter()
+	filter["certname"] = certname
+	records, err := findKeyLogRecords(d, filter)
+	if err != nil {
+		return nil, err
 	}
-	defer dbConn.Disconnect(context.TODO())
-	return arr, nil
+	grouped := groupKeyLogRecords(records)
+	if len(grouped) == 0 {
+		return nil, ErrEntryNotFound
+	}
+	return &grouped[0], nil
 }
 
-func GetOneCertnameLogEntry(d Database, certname string) (*HieraHostDBEntry, error) {
+func findKeyLogRecords(d Database, filter bson.M) ([]HieraKeyLogRecord, error) {
 	dbConn, err := NewClient(d)
 	if err != nil {
 		return nil, err
 	}
-	collection := dbConn.Database(d.Database).Collection("logging")
+	defer dbConn.Disconnect(context.TODO())
+	collection := dbConn.Database(d.Database).Collection("keylog")
 	findOptions := options.Find()
-	findOptions.SetLimit(1)
-	filter := bson.D{{"_id", certname}}
-	var result *HieraHostDBEntry
-	// Finding multiple documents returns a cursor
+	findOptions.SetSort(bson.D{{Key: "certname", Value: 1}, {Key: "key", Value: 1}})
 	cur, err := collection.Find(context.TODO(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+	records := []HieraKeyLogRecord{}
 	for cur.Next(context.TODO()) {
-		var elem HieraHostDBEntry
+		var elem HieraKeyLogRecord
 		err := cur.Decode(&elem)
 		if err != nil {
 			log.Println(err.Error())
 		} else {
-			result = &elem
+			records = append(records, elem)
 		}
 	}
-	//err = curr.Decode(result)
-	if err != nil {
-		return nil, err
-	}
-	defer dbConn.Disconnect(context.TODO())
-	if result != nil {
-		return result, nil
+	return records, cur.Err()
+}
+
+// groupKeyLogRecords turns the per key records back into one entry per certname. The records must be sorted on certname.
+func groupKeyLogRecords(records []HieraKeyLogRecord) []HieraHostDBEntry {
+	arr := []HieraHostDBEntry{}
+	for _, r := range records {
+		if len(arr) == 0 || arr[len(arr)-1].ID != r.Certname {
+			arr = append(arr, HieraHostDBEntry{ID: r.Certname, Entries: []HieraHostDBLogEntry{}})
+		}
+		last := &arr[len(arr)-1]
+		last.Entries = append(last.Entries, r.toLogEntry())
 	}
-	return nil, errors.New("Entry not found")
+	return arr
 }
 
-func InsertLogEntry(e HieraHostDBEntry, d Database) (*string, error) {
+func (r HieraKeyLogRecord) toLogEntry() HieraHostDBLogEntry {
+	lastSeen := r.LastSeen.Local().Format(LAYOUT)
+	firstSeen := r.FirstSeen.Local().Format(LAYOUT)
+	return HieraHostDBLogEntry{
+		Certname:  r.Certname,
+		Key:       r.Key,
+		Date:      &lastSeen,
+		FirstSeen: &firstSeen,
+		Count:     r.Count,
+		Level:     r.Level,
+		File:      r.File,
+		ValueHash: r.ValueHash,
+	}
+}
 
-	dbConn, err := NewClient(d)
-	if err != nil {
-		return nil, err
+// seenAt returns the time the lookup was made, or now when it has no valid date
+func (e HieraHostDBLogEntry) seenAt() time.Time {
+	if e.Date != nil {
+		t, err := time.Parse(LAYOUT, *e.Date)
+		if err == nil {
+			return t
+		}
 	}
-	collection := dbConn.Database(d.Database).Collection("logging")
-	insertResult, err := collection.InsertOne(context.TODO(), e)
-	if err != nil {
-		return nil, err
+	return time.Now()
+}
+
+// seenAtFirst returns the first time the key was looked up, or the time of the lookup when it has no valid first seen date
+func (e HieraHostDBLogEntry) seenAtFirst() time.Time {
+	if e.FirstSeen != nil {
+		t, err := time.Parse(LAYOUT, *e.FirstSeen)
+		if err == nil {
+			return t
+		}
 	}
-	defer dbConn.Disconnect(context.TODO())
-	str := fmt.Sprintf("Inserted one entry id: %s", insertResult.InsertedID)
-	return &str, nil
+	return e.seenAt()
 }
 
-func InsertLogEntryWrapper(e HieraHostDBEntry, d Conf) (*string, error) {
-	// first see if entry exists
-	e2, err := GetOneCertnameLogEntry(d.DB, e.ID)
-	// if noet we can Insert
-	if e2 == nil || err != nil {
-		res, err := InsertLogEntry(e, d.DB)
-		return res, err
-	} else {
-		if e2 != nil {
-			cleaned := RemoveOldLogEntries(*e2, d, e.Entries[0])
-			res, err := UpdateLogEntryDB(cleaned, d.DB)
-			if err != nil {
-				log.Println(err.Error())
+// mergeLogEntries merges the entries of the same key of the same certname, the unique index of the key log does not allow
+// two upserts of one key in the same unordered bulk write. The merged entry counts all of their lookups and has the
+// newest date and provenance. For every merged entry it returns the indexes of the entries it holds.
+func mergeLogEntries(entries []HieraHostDBLogEntry) ([]HieraHostDBLogEntry, [][]int) {
+	merged := []HieraHostDBLogEntry{}
+	indexes := [][]int{}
+	positions := map[[2]string]int{}
+	for i, e := range entries {
+		if e.lookups == 0 {
+			e.lookups = 1
+		}
+		id := [2]string{e.Certname, e.Key}
+		p, ok := positions[id]
+		if !ok {
+			positions[id] = len(merged)
+			merged = append(merged, e)
+			indexes = append(indexes, []int{i})
+			continue
+		}
+		indexes[p] = append(indexes[p], i)
+		m := &merged[p]
+		m.lookups += e.lookups
+		if e.Count > m.Count {
+			m.Count = e.Count
+		}
+		if e.seenAtFirst().Before(m.seenAtFirst()) {
+			m.FirstSeen = e.FirstSeen
+			if m.FirstSeen == nil {
+				m.FirstSeen = e.Date
+			}
+		}
+		if e.seenAt().After(m.seenAt()) {
+			m.Date = e.Date
+			if e.Environment != "" {
+				m.Environment = e.Environment
+			}
+			if e.Level != "" {
+				m.Level = e.Level
+			}
+			if e.File != "" {
+				m.File = e.File
+			}
+			if e.ValueHash != "" {
+				m.ValueHash = e.ValueHash
 			}
-			return res, err
 		}
-
 	}
-	return nil, errors.New("Something went wrong with code logic")
+	return merged, indexes
 }
 
-func UpdateLogEntryDB(e HieraHostDBEntry, d Database) (*string, error) {
-	ks, _ := GetOneCertnameLogEntry(d, e.ID)
-	if ks == nil {
-		return nil, errors.New("Entry not found")
+// keyLogUpsertModel creates an atomic upsert for one looked up key. The first and last seen dates only ever move outwards
+// so entries may be written in any order. The provenance of the value is only replaced when it is given. Only imported
+// entries that come from an export keep their own first seen date and count, the count is raised to the exported count
+// instead of added so importing the same export twice does not change the key log.
+func keyLogUpsertModel(e HieraHostDBLogEntry, imported bool) mongo.WriteModel {
+	seen := e.seenAt()
+	first := seen
+	if imported && e.seenAtFirst().Before(seen) {
+		first = e.seenAtFirst()
+	}
+	lookups := e.lookups
+	if lookups == 0 {
+		lookups = 1
+	}
+	update := bson.M{
+		"$min": bson.M{"first_seen": first},
+		"$max": bson.M{"last_seen": seen},
+		"$inc": bson.M{"count": lookups},
+	}
+	if imported && e.Count > 0 {
+		delete(update, "$inc")
+		update["$max"] = bson.M{"last_seen": seen, "count": e.Count}
 	}
+	provenance := bson.M{}
+	if e.Level != "" {
+		provenance["level"] = e.Level
+	}
+	if e.File != "" {
+		provenance["file"] = e.File
+	}
+	if e.ValueHash != "" {
+		provenance["value_hash"] = e.ValueHash
+	}
+	if len(provenance) > 0 {
+		update["$set"] = provenance
+	}
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"certname": e.Certname, "key": e.Key}).
+		SetUpdate(update).
+		SetUpsert(true)
+}
 
-	dbConn, err := NewClient(d)
+// UpsertLogEntries writes the given lookups to the key log in one unordered bulk write.
+func UpsertLogEntries(entries []HieraHostDBLogEntry, d Database) (*string, error) {
+	writeErrors, err := BulkUpsertLogEntries(entries, d)
 	if err != nil {
-		return nil, errors.New("Database connection failed")
+		return nil, err
+	}
+	for _, werr := range writeErrors {
+		return nil, werr
 	}
+	str := fmt.Sprintf("Logged %d entries", len(entries))
+	return &str, nil
+}
 
-	collection := dbConn.Database(d.Database).Collection("logging")
-	var result *mongo.UpdateResult
-	selector := bson.M{"_id": e.ID}
-	//updator :=  r.ToBSON()
-	result, err = collection.ReplaceOne(context.TODO(), selector, e)
+// BulkUpsertLogEntries writes the given lookups to the key log in one unordered bulk write. Lookups that could not be
+// written are returned by their index, the error is only set when the whole write failed. Lookups that belong to a
+// run are also added to that run. Every lookup counts once, a count or first seen date sent with it is ignored.
+func BulkUpsertLogEntries(entries []HieraHostDBLogEntry, d Database) (map[int]error, error) {
+	return bulkUpsertLogEntries(entries, d, false)
+}
 
+func bulkUpsertLogEntries(entries []HieraHostDBLogEntry, d Database, imported bool) (map[int]error, error) {
+	writeErrors := map[int]error{}
+	if len(entries) == 0 {
+		return writeErrors, nil
+	}
+	dbConn, err := NewClient(d)
 	if err != nil {
 		return nil, err
 	}
 	defer dbConn.Disconnect(context.TODO())
+	merged, indexes := mergeLogEntries(entries)
+	models := []mongo.WriteModel{}
+	for _, e := range merged {
+		models = append(models, keyLogUpsertModel(e, imported))
+	}
+	collection := dbConn.Database(d.Database).Collection("keylog")
+	_, err = collection.BulkWrite(context.TODO(), models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		bulkErr, ok := err.(mongo.BulkWriteException)
+		if !ok || len(bulkErr.WriteErrors) == 0 {
+			return nil, err
+		}
+		for _, werr := range bulkErr.WriteErrors {
+			for _, i := range indexes[werr.Index] {
+				writeErrors[i] = werr.WriteError
+			}
+		}
+	}
 
-	str := fmt.Sprintf("Updated number of entries: %d", result.MatchedCount)
-	return &str, nil
+	runModels := []mongo.WriteModel{}
+	for i, e := range entries {
+		if _, failed := writeErrors[i]; !failed && e.RunID != "" {
+			runModels = append(runModels, runUpsertModel(e))
+		}
+	}
+	if len(runModels) > 0 {
+		runs := dbConn.Database(d.Database).Collection("runs")
+		_, err = runs.BulkWrite(context.TODO(), runModels, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+	return writeErrors, nil
 }
 
-func RemoveOldLogEntries(e HieraHostDBEntry, conf Conf, entry HieraHostDBLogEntry) HieraHostDBEntry {
-	entries := []HieraHostDBLogEntry{}
-	for _, k := range e.Entries {
+// EnsureKeyLogIndexes creates the indexes of the key log and the runs. Both get a TTL index that expires entries which
+// have not been looked up for the given duration. When the TTL changed in the configuration the old TTL index is replaced.
+func EnsureKeyLogIndexes(d Database, ttl time.Duration) error {
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	err = ensureIndexesWithTTL(dbConn.Database(d.Database).Collection("keylog"), ttl, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "certname", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetName("certname_key").SetUnique(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return ensureIndexesWithTTL(dbConn.Database(d.Database).Collection("runs"), ttl, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "certname", Value: 1}, {Key: "first_seen", Value: -1}},
+			Options: options.Index().SetName("certname_first_seen"),
+		},
+	})
+}
 
-		//str := currentTime.Format(LAYOUT)
-		if k.Date != nil && k.Key != entry.Key {
+func ensureIndexesWithTTL(collection *mongo.Collection, ttl time.Duration, models []mongo.IndexModel) error {
+	indexes := collection.Indexes()
+	expireAfter := int32(ttl.Seconds())
 
-			diff := GetDiffBetweenDates(k.Date)
-			if diff < conf.KeyTTLMinutes {
-				entries = append(entries, k)
+	cur, err := indexes.List(context.TODO())
+	if err != nil {
+		return err
+	}
+	for cur.Next(context.TODO()) {
+		var idx bson.M
+		if err := cur.Decode(&idx); err != nil {
+			continue
+		}
+		if idx["name"] == "last_seen_ttl" && fmt.Sprintf("%v", idx["expireAfterSeconds"]) != fmt.Sprintf("%d", expireAfter) {
+			if _, err := indexes.DropOne(context.TODO(), "last_seen_ttl"); err != nil {
+				cur.Close(context.TODO())
+				return err
 			}
+		}
+	}
+	cur.Close(context.TODO())
+
+	models = append(models, mongo.IndexModel{
+		Keys:    bson.D{{Key: "last_seen", Value: 1}},
+		Options: options.Index().SetName("last_seen_ttl").SetExpireAfterSeconds(expireAfter),
+	})
+	_, err = indexes.CreateMany(context.TODO(), models)
+	return err
+}
+
+// MigrateLegacyLogEntries moves the old one document per certname entries from the logging collection into the key log.
+// Every converted document is removed so the migration can safely be run on each start. The lookups of a document are
+// written with their count like an import, so a document that is migrated again after a crash is not counted twice.
+// The collection is only dropped when every document was migrated, documents that can not be read are kept.
+func MigrateLegacyLogEntries(d Database) (int, error) {
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return 0, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	legacy := dbConn.Database(d.Database).Collection("logging")
+	keylog := dbConn.Database(d.Database).Collection("keylog")
 
+	cur, err := legacy.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(context.TODO())
+	migrated := 0
+	failed := 0
+	for cur.Next(context.TODO()) {
+		var elem HieraHostDBEntry
+		err := cur.Decode(&elem)
+		if err != nil {
+			log.Println(err.Error())
+			failed++
+			continue
+		}
+		entries := []HieraHostDBLogEntry{}
+		for _, e := range elem.Entries {
+			if e.Key == "" {
+				continue
+			}
+			e.Certname = elem.ID
+			entries = append(entries, e)
+		}
+		models := []mongo.WriteModel{}
+		for _, e := range mergeLookups(entries) {
+			models = append(models, keyLogUpsertModel(e, true))
+		}
+		if len(models) > 0 {
+			_, err = keylog.BulkWrite(context.TODO(), models, options.BulkWrite().SetOrdered(false))
+			if err != nil {
+				return migrated, err
+			}
 		}
+		_, err = legacy.DeleteOne(context.TODO(), bson.M{"_id": elem.ID})
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	if err := cur.Err(); err != nil {
+		return migrated, err
+	}
+	if failed > 0 {
+		log.Printf("%d legacy log documents could not be read and are left in the logging collection", failed)
+		return migrated, nil
+	}
+	if migrated > 0 {
+		err = legacy.Drop(context.TODO())
 	}
-	entries = append(entries, entry)
-	e.Entries = entries
-	return e
+	return migrated, err
 }
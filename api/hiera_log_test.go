@@ -0,0 +1,30 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeLogEntries(t *testing.T) {
+	date := func(s string) *string { return &s }
+	entries := []HieraHostDBLogEntry{
+		{Certname: "web01", Key: "ntp::servers", Date: date("2020-05-11T10:00:00+0000"), Level: "common"},
+		{Certname: "db01", Key: "ntp::servers", Date: date("2020-05-11T10:00:00+0000")},
+		{Certname: "web01", Key: "ntp::servers", Date: date("2020-05-11T12:00:00+0000"), File: "nodes/web01.yaml"},
+		{Certname: "web01", Key: "ntp::servers", Date: date("2020-05-11T08:00:00+0000")},
+	}
+	merged, indexes := mergeLogEntries(entries)
+	if len(merged) != 2 || !reflect.DeepEqual(indexes, [][]int{{0, 2, 3}, {1}}) {
+		t.Fatalf("got %+v with the indexes %v", merged, indexes)
+	}
+	web := merged[0]
+	if web.lookups != 3 || *web.Date != "2020-05-11T12:00:00+0000" || *web.FirstSeen != "2020-05-11T08:00:00+0000" {
+		t.Errorf("got %+v", web)
+	}
+	if web.Level != "common" || web.File != "nodes/web01.yaml" {
+		t.Errorf("the provenance is %s %s", web.Level, web.File)
+	}
+	if merged[1].lookups != 1 || merged[1].FirstSeen != nil {
+		t.Errorf("got %+v", merged[1])
+	}
+}
@@ -3,9 +3,7 @@ package api
 import (
 	"errors"
 	"fmt"
-	"github.com/akira/go-puppetdb"
 	"github.com/gin-gonic/gin"
-	"github.com/jeremywohl/flatten"
 	"log"
 	"net/http"
 	"regexp"
@@ -97,19 +95,35 @@ func GetHierarchyForCertname(conf Conf, certname string) (*HierarchyResult, erro
 
 	facts := GetFactsMapForCertName(conf, certname)
 	if len(facts) == 0 {
-		return nil, errors.New("No facts found for node are you sure node exists or PuppetDB connection is valid")
+		return nil, errors.New("No facts found for node are you sure node exists or the facts source is valid")
 
 	} else {
-		for _, v := range h.Variables {
-			for index, p := range h.Paths {
-				if val, ok := facts[v]; ok {
-					str := ReplaceFactInString(p, v, val.(string))
-					h.Paths[index] = str
-				}
+		h = ResolveHierarchy(h, facts)
+		return &h, nil
+	}
+}
+
+// ResolveHierarchy replaces the facts in the paths of the hierarchy with their values
+func ResolveHierarchy(h HierarchyResult, facts map[string]interface{}) HierarchyResult {
+	paths := make([]string, len(h.Paths))
+	copy(paths, h.Paths)
+	for _, v := range h.Variables {
+		for index, p := range paths {
+			if val, ok := hierarchyFact(facts, v); ok {
+				paths[index] = ReplaceFactInString(p, v, fmt.Sprint(val))
 			}
 		}
-		return &h, nil
 	}
+	return HierarchyResult{Paths: paths, Variables: h.Variables}
+}
+
+// hierarchyFact returns the value of a variable of the hierarchy, %{facts.name} is the same fact as %{name}
+func hierarchyFact(facts map[string]interface{}, variable string) (interface{}, bool) {
+	if val, ok := facts[variable]; ok {
+		return val, true
+	}
+	val, ok := facts[strings.TrimPrefix(variable, "facts.")]
+	return val, ok
 }
 
 func ReplaceFactInString(path string, factName string, value string) string {
@@ -120,44 +134,19 @@ func ReplaceFactInString(path string, factName string, value string) string {
 	return str
 }
 
+// GetFactsMapForCertName returns the facts of the node from the facts cache or the configured facts source
 func GetFactsMapForCertName(conf Conf, certname string) map[string]interface{} {
-	var cl *puppetdb.Client
-	if !conf.Puppet.SSL {
-		cl = puppetdb.NewClient(conf.Puppet.Host, conf.Puppet.Port, false)
-
-	} else {
-		if conf.Puppet.Insecure {
-			cl = puppetdb.NewClientSSLInsecure(conf.Puppet.Host, conf.Puppet.Port, false)
-
-		} else {
-			cl = puppetdb.NewClientSSL(conf.Puppet.Host, conf.Puppet.Port, conf.Puppet.Key, conf.Puppet.Cert, conf.Puppet.Ca, false)
-
-		}
+	provider, err := NewFactsProvider(conf)
+	if err != nil {
+		log.Println(err.Error())
+		return map[string]interface{}{}
 	}
-	facts, _ := cl.NodeFacts(certname)
-	mapy := make(map[string]interface{})
-	for i, fact := range facts {
-		if i == 0 {
-			mapy["environment"] = fact.Environment
-		}
-		switch (fact.Value.Data()).(type) {
-		case map[string]interface{}:
-			nested := fact.Value.Data().(map[string]interface{})
-			flat, err := flatten.Flatten(nested, "", flatten.DotStyle)
-			if err != nil {
-				log.Println(err.Error())
-			}
-			for k, v := range flat {
-				mapy[fact.Name+"."+k] = v
-
-			}
-		case interface{}:
-			mapy[fact.Name] = fact.Value.Data()
-		default:
-			log.Println("Unknown data type was parsed in facts of this host " + certname + " fact " + fact.Name)
-		}
+	facts, err := factsCache.Get(provider, certname)
+	if err != nil {
+		log.Println(err.Error())
+		return map[string]interface{}{}
 	}
-	return mapy
+	return facts
 }
 
 // getFactsFromPath gets the fact names from the hiera path
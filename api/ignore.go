@@ -0,0 +1,382 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IgnoreRule hides keys and paths that are unused on purpose from the clean reports. Every field that is set has to
+// match. Globs use the shell pattern syntax where * does not match a /. Path globs match the full path or the path
+// relative to the datadir. A rule with a certname only applies to the results of that node.
+type IgnoreRule struct {
+	ID       string     `bson:"_id" json:"id"`
+	Key      string     `bson:"key,omitempty" json:"key,omitempty"`
+	KeyGlob  string     `bson:"key_glob,omitempty" json:"key_glob,omitempty"`
+	PathGlob string     `bson:"path_glob,omitempty" json:"path_glob,omitempty"`
+	Certname string     `bson:"certname,omitempty" json:"certname,omitempty"`
+	Reason   string     `bson:"reason" json:"reason"`
+	Owner    string     `bson:"owner,omitempty" json:"owner,omitempty"`
+	Expires  *time.Time `bson:"expires,omitempty" json:"expires,omitempty"`
+	Created  time.Time  `bson:"created" json:"created"`
+}
+
+// IgnoreRuleStatus shows if a rule still hides anything in the stored clean results. A rule is stale when it expired or
+// does not match anything anymore.
+type IgnoreRuleStatus struct {
+	Rule    IgnoreRule `json:"rule"`
+	Matches int        `json:"matches"`
+	Expired bool       `json:"expired"`
+	Stale   bool       `json:"stale"`
+}
+
+// IgnoreMatcher applies the rules that did not expire to the clean results and counts how often every rule matched
+type IgnoreMatcher struct {
+	rules   []IgnoreRule
+	datadir string
+	Hits    map[string]int
+}
+
+// Validate checks that the rule matches something and that its globs are valid
+func (r IgnoreRule) Validate() error {
+	if r.Key == "" && r.KeyGlob == "" && r.PathGlob == "" && r.Certname == "" {
+		return errors.New("A rule needs a key, key_glob, path_glob or certname")
+	}
+	if r.Reason == "" {
+		return errors.New("A rule needs a reason")
+	}
+	for _, glob := range []string{r.KeyGlob, r.PathGlob} {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("Invalid glob %s", glob)
+		}
+	}
+	return nil
+}
+
+// Expired returns if the rule expired at the given time
+func (r IgnoreRule) Expired(now time.Time) bool {
+	return r.Expires != nil && r.Expires.Before(now)
+}
+
+// matches checks the rule against a key and path of a node. An empty certname is the result of the whole estate, an
+// empty key or path is an item that has none.
+func (r IgnoreRule) matches(certname string, key string, p string, datadir string) bool {
+	if r.Certname != "" && r.Certname != certname {
+		return false
+	}
+	if r.Key != "" && r.Key != key {
+		return false
+	}
+	if r.KeyGlob != "" {
+		if ok, _ := path.Match(r.KeyGlob, key); !ok || key == "" {
+			return false
+		}
+	}
+	if r.PathGlob != "" {
+		if p == "" {
+			return false
+		}
+		relative := strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(p), filepath.ToSlash(datadir)), "/")
+		full, _ := path.Match(r.PathGlob, filepath.ToSlash(p))
+		rel, _ := path.Match(r.PathGlob, relative)
+		if !full && !rel {
+			return false
+		}
+	}
+	return true
+}
+
+// NewIgnoreMatcher creates a matcher with the rules that did not expire
+func NewIgnoreMatcher(rules []IgnoreRule, datadir string) *IgnoreMatcher {
+	m := &IgnoreMatcher{datadir: datadir, Hits: map[string]int{}}
+	now := time.Now()
+	for _, r := range rules {
+		if !r.Expired(now) {
+			m.rules = append(m.rules, r)
+		}
+	}
+	return m
+}
+
+// GetIgnoreMatcher loads the rules and creates a matcher. When the rules can not be loaded nothing is ignored.
+func GetIgnoreMatcher(conf Conf) *IgnoreMatcher {
+	rules, err := GetIgnoreRules(conf.DB)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return NewIgnoreMatcher(rules, conf.DataDir)
+}
+
+// Ignored returns if any rule matches, the hits of every matching rule are counted
+func (m *IgnoreMatcher) Ignored(certname string, key string, p string) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.matches(certname, key, p, m.datadir) {
+			m.Hits[r.ID]++
+			ignored = true
+		}
+	}
+	return ignored
+}
+
+// filterEntries removes the ignored paths of every key, keys without paths left are removed
+func (m *IgnoreMatcher) filterEntries(certname string, entries []InLogAndHieraEntry) []InLogAndHieraEntry {
+	filtered := []InLogAndHieraEntry{}
+	for _, e := range entries {
+		paths := []string{}
+		for _, p := range e.Paths {
+			if !m.Ignored(certname, e.Key, p) {
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) > 0 {
+			e.Paths = paths
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// FilterCleanResult removes the ignored keys from the clean result of a node. Keys that are in the log and in hiera
+// are not unused so they are left alone.
+func (m *IgnoreMatcher) FilterCleanResult(certname string, res YamlCleanResult) YamlCleanResult {
+	notInHiera := []string{}
+	for _, key := range res.InLogNotInHiera {
+		if !m.Ignored(certname, key, "") {
+			notInHiera = append(notInHiera, key)
+		}
+	}
+	res.InLogNotInHiera = notInHiera
+	res.InHieraNotInLog = m.filterEntries(certname, res.InHieraNotInLog)
+	res.DuplicateData = m.filterEntries(certname, res.DuplicateData)
+	return res
+}
+
+// FilterCleanAllResult removes the ignored keys and paths from the clean all result
+func (m *IgnoreMatcher) FilterCleanAllResult(res CleanAllResult) CleanAllResult {
+	paths := []string{}
+	for _, p := range res.PathsNeverUsed {
+		if !m.Ignored("", "", p) {
+			paths = append(paths, p)
+		}
+	}
+	res.PathsNeverUsed = paths
+	keys := []YamlKeyPath{}
+	for _, k := range res.KeysNeverUsed {
+		kp := []string{}
+		for _, p := range k.Paths {
+			if !m.Ignored("", k.Key, p) {
+				kp = append(kp, p)
+			}
+		}
+		if len(kp) > 0 {
+			k.Paths = kp
+			keys = append(keys, k)
+		}
+	}
+	res.KeysNeverUsed = keys
+	return res
+}
+
+// GetIgnoreRulesEndpoint example
+// @Summary Get the ignore rules
+// @Description Lists the rules that hide intentionally unused keys and paths from the clean reports.
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []IgnoreRule
+// @Failure 500 {object} APIMessage "Something went wrong getting the rules"
+// @Router /ignore [get]
+func GetIgnoreRulesEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		rules, err := GetIgnoreRules(conf.DB)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		} else {
+			c.JSON(http.StatusOK, rules)
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// PostIgnoreRuleEndpoint example
+// @Summary Add an ignore rule
+// @Description Adds a rule that hides keys or paths from /v1/clean, /v1/clean-all, /v1/clean-nodes and the influx export. Set at least one of key, key_glob, path_glob or certname and a reason. A rule with an expiry date stops applying after that date.
+// @Param  rule  body  IgnoreRule  true  "The rule, the id and created date are set by arvo"
+// @Accept  json
+// @Produce  json
+// @Success 201 {object} IgnoreRule
+// @Failure 400 {object} APIMessage "The rule is not valid"
+// @Failure 500 {object} APIMessage "Something went wrong saving the rule"
+// @Router /ignore [post]
+func PostIgnoreRuleEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		var rule IgnoreRule
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		if err := rule.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		rule.ID = primitive.NewObjectID().Hex()
+		rule.Created = time.Now()
+		if err := InsertIgnoreRule(conf.DB, rule); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, rule)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// DeleteIgnoreRuleEndpoint example
+// @Summary Delete an ignore rule
+// @Param  id     path   string     true  "Some ID"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} APIMessage
+// @Failure 404 {object} APIMessage "The rule was not found"
+// @Router /ignore/{id} [delete]
+func DeleteIgnoreRuleEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var u1 JSONID
+		c.ShouldBindUri(&u1)
+		defer c.Done()
+		err := DeleteIgnoreRule(conf.DB, u1.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": err.Error()})
+		} else {
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "Deleted the rule"})
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// IgnoreReportEndpoint example
+// @Summary Get the ignore rules that are stale
+// @Description Checks every rule against the stored clean all result and node results. A rule is stale when it expired or no longer matches anything, so it can be removed. Refresh the results first to get an up to date report.
+// @Param  stale  query  bool       false "Only show the stale rules"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []IgnoreRuleStatus
+// @Failure 500 {object} APIMessage "Something went wrong getting the rules"
+// @Router /ignore/report [get]
+func IgnoreReportEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		report, err := GetIgnoreReport(conf)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		if c.Query("stale") == "true" {
+			stale := []IgnoreRuleStatus{}
+			for _, s := range report {
+				if s.Stale {
+					stale = append(stale, s)
+				}
+			}
+			report = stale
+		}
+		c.JSON(http.StatusOK, report)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// GetIgnoreReport counts how often every rule matches the stored clean all result and node results
+func GetIgnoreReport(conf Conf) ([]IgnoreRuleStatus, error) {
+	rules, err := GetIgnoreRules(conf.DB)
+	if err != nil {
+		return nil, err
+	}
+	m := NewIgnoreMatcher(rules, conf.DataDir)
+	// without a clean all result only the node results are checked
+	full, err := GetFullCleanResultEntry(conf.DB)
+	if err != nil && err != ErrEntryNotFound {
+		return nil, err
+	}
+	if full != nil {
+		m.FilterCleanAllResult(*full)
+	}
+	err = ForEachNodeCleanResult(conf.DB, func(n NodeCleanResult) {
+		m.FilterCleanResult(n.Certname, n.Result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	report := []IgnoreRuleStatus{}
+	for _, r := range rules {
+		s := IgnoreRuleStatus{Rule: r, Matches: m.Hits[r.ID], Expired: r.Expired(now)}
+		s.Stale = s.Expired || s.Matches == 0
+		report = append(report, s)
+	}
+	return report, nil
+}
+
+// GetIgnoreRules gets every ignore rule, oldest first
+func GetIgnoreRules(d Database) ([]IgnoreRule, error) {
+	rules := []IgnoreRule{}
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return rules, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	findOptions := options.Find().SetSort(bson.D{{Key: "created", Value: 1}})
+	cur, err := dbConn.Database(d.Database).Collection("ignorerules").Find(context.TODO(), bson.M{}, findOptions)
+	if err != nil {
+		return rules, err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var elem IgnoreRule
+		err := cur.Decode(&elem)
+		if err != nil {
+			log.Println(err.Error())
+		} else {
+			rules = append(rules, elem)
+		}
+	}
+	return rules, cur.Err()
+}
+
+// InsertIgnoreRule stores a new ignore rule
+func InsertIgnoreRule(d Database, rule IgnoreRule) error {
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	_, err = dbConn.Database(d.Database).Collection("ignorerules").InsertOne(context.TODO(), rule)
+	return err
+}
+
+// DeleteIgnoreRule removes an ignore rule
+func DeleteIgnoreRule(d Database, id string) error {
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	res, err := dbConn.Database(d.Database).Collection("ignorerules").DeleteOne(context.TODO(), bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return errors.New("Entry not found")
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ImportResult shows what an import of historical lookups did
+type ImportResult struct {
+	Success    bool     `json:"success"`
+	Lines      int      `json:"lines"`
+	Lookups    int      `json:"lookups"`
+	Imported   int      `json:"imported"`
+	Unassigned int      `json:"unassigned"`
+	Failed     int      `json:"failed"`
+	Errors     []string `json:"errors"`
+}
+
+// maxImportErrors is the amount of errors an import result shows, the rest is only counted
+const maxImportErrors = 20
+
+// importBatchSize is the amount of lookups that are written in one bulk write during an import
+const importBatchSize = 1000
+
+var (
+	puppetserverLineRegex    = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?)\s+(\w+)\s+\[([^\]]+)\]\s+(?:\[[^\]]+\]\s+)?(.*)$`)
+	puppetserverLookupRegex  = regexp.MustCompile(`(?:Automatic Parameter )?Lookup of '([^']+)'`)
+	puppetserverCatalogRegex = regexp.MustCompile(`(Compiling|Compiled) catalog for (\S+?)(?: in environment (\S+?))?(?: in [\d.]+ seconds)?$`)
+	puppetserverTimeLayouts  = []string{
+		"2006-01-02T15:04:05.999999999Z07:00",
+		"2006-01-02T15:04:05.999999999Z0700",
+		"2006-01-02T15:04:05Z07:00",
+		"2006-01-02 15:04:05,999",
+		"2006-01-02 15:04:05",
+	}
+)
+
+// ImportKeysEndpoint example
+// @Summary Import historical lookups
+// @Description Imports lookups from a puppetserver log file or from the jsonl export of arvo into the key log, keeping their original timestamps. Post the file as the body. Puppetserver lookups are assigned to the node whose catalog was compiled on the same thread, pass certname to assign all lookups to one node.
+// @Param  format    query  string     false "puppetserver or jsonl, puppetserver by default"
+// @Param  certname  query  string     false "Assign every lookup of a puppetserver log to this certname"
+// @Accept  plain
+// @Produce  json
+// @Success 200 {object} ImportResult
+// @Failure 400 {object} APIMessage "Unknown format or the body could not be read"
+// @Failure 500 {object} APIMessage "Something went wrong importing the lookups"
+// @Router /keys/import [post]
+func ImportKeysEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		var entries []HieraHostDBLogEntry
+		var res ImportResult
+		var err error
+		limit := int64(conf.ImportMaxMB) << 20
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		switch c.DefaultQuery("format", "puppetserver") {
+		case "puppetserver":
+			entries, res, err = ParsePuppetserverLog(body, c.Query("certname"))
+		case "jsonl":
+			entries, res, err = ParseKeyExport(body)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Format must be puppetserver or jsonl"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("%s, an import may be at most %d bytes", err.Error(), limit)})
+			return
+		}
+		err = ImportLogEntries(entries, conf.DB, &res)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// ExportKeysEndpoint example
+// @Summary Export the logged keys
+// @Description Exports the key log as newline delimited json with one logged key per line. The export can be imported again with the jsonl format of the import endpoint.
+// @Param  since  query  string     false "Only keys looked up after this time (RFC3339, date or duration like 30d)"
+// @Param  until  query  string     false "Only keys looked up before this time (RFC3339, date or duration like 30d)"
+// @Produce  application/x-ndjson
+// @Success 200 {object} HieraHostDBLogEntry "One logged key per line"
+// @Failure 500 {object} APIMessage "Something went wrong getting the entries"
+// @Router /keys/export [get]
+func ExportKeysEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		w, err := GetTimeWindowFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		hosts, err := GetAllCertnameLogEntry(conf.DB, w)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+		encoder := json.NewEncoder(c.Writer)
+		for _, h := range hosts {
+			for _, e := range h.Entries {
+				encoder.Encode(e)
+			}
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// ImportLogEntries writes the parsed lookups in batches and adds the outcome to the import result. Exported entries keep
+// their count and first seen date.
+func ImportLogEntries(entries []HieraHostDBLogEntry, d Database, res *ImportResult) error {
+	for start := 0; start < len(entries); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		writeErrors, err := bulkUpsertLogEntries(entries[start:end], d, true)
+		if err != nil {
+			return err
+		}
+		for _, werr := range writeErrors {
+			res.addError(werr.Error())
+		}
+		res.Failed += len(writeErrors)
+		res.Imported += end - start - len(writeErrors)
+	}
+	res.Success = res.Failed == 0
+	return nil
+}
+
+func (res *ImportResult) addError(message string) {
+	if len(res.Errors) < maxImportErrors {
+		res.Errors = append(res.Errors, message)
+	}
+}
+
+// ParsePuppetserverLog reads the hiera lookups from a puppetserver log. Puppetserver does not log the node with every
+// lookup, so lookups are kept per thread until the catalog compiled on that thread names its node. When a certname
+// is given every lookup is assigned to it instead. The lookups of a key by a node are merged into one entry with their
+// count, so importing the same log twice does not count them twice. The error is set when the log could not be read.
+func ParsePuppetserverLog(r io.Reader, certname string) ([]HieraHostDBLogEntry, ImportResult, error) {
+	res := ImportResult{Errors: []string{}}
+	entries := []HieraHostDBLogEntry{}
+	pending := map[string][]HieraHostDBLogEntry{}
+	current := map[string]string{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		res.Lines++
+		match := puppetserverLineRegex.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		date, thread, message := match[1], match[3], strings.TrimSpace(match[4])
+
+		if catalog := puppetserverCatalogRegex.FindStringSubmatch(message); catalog != nil {
+			node, env := catalog[2], catalog[3]
+			for _, e := range pending[thread] {
+				e.Certname = node
+				e.Environment = env
+				entries = append(entries, e)
+			}
+			delete(pending, thread)
+			if catalog[1] == "Compiling" {
+				current[thread] = node
+			} else {
+				delete(current, thread)
+			}
+			continue
+		}
+
+		lookup := puppetserverLookupRegex.FindStringSubmatch(message)
+		if lookup == nil || IsReservedHieraKey(lookup[1]) {
+			continue
+		}
+		t, err := parsePuppetserverTime(date)
+		if err != nil {
+			res.addError(fmt.Sprintf("line %d: %s", res.Lines, err.Error()))
+			continue
+		}
+		str := t.Format(LAYOUT)
+		e := HieraHostDBLogEntry{Key: lookup[1], Date: &str}
+		res.Lookups++
+		if certname != "" {
+			e.Certname = certname
+			entries = append(entries, e)
+		} else if node, ok := current[thread]; ok {
+			e.Certname = node
+			entries = append(entries, e)
+		} else {
+			pending[thread] = append(pending[thread], e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, res, fmt.Errorf("line %d could not be read: %s", res.Lines+1, err.Error())
+	}
+	for _, p := range pending {
+		res.Unassigned += len(p)
+	}
+	return mergeLookups(entries), res, nil
+}
+
+// mergeLookups merges the lookups of the same key by the same node into one entry that counts them, from the first to
+// the last time it was looked up. The order of the first lookups is kept.
+func mergeLookups(entries []HieraHostDBLogEntry) []HieraHostDBLogEntry {
+	merged := []HieraHostDBLogEntry{}
+	index := map[[2]string]int{}
+	for _, e := range entries {
+		id := [2]string{e.Certname, e.Key}
+		i, ok := index[id]
+		if !ok {
+			e.FirstSeen = e.Date
+			e.Count = 1
+			index[id] = len(merged)
+			merged = append(merged, e)
+			continue
+		}
+		m := &merged[i]
+		m.Count++
+		seen := e.seenAt()
+		if seen.Before(m.seenAtFirst()) {
+			m.FirstSeen = e.Date
+		}
+		if seen.After(m.seenAt()) {
+			m.Date = e.Date
+			m.Environment = e.Environment
+		}
+	}
+	return merged
+}
+
+func parsePuppetserverTime(str string) (time.Time, error) {
+	for _, layout := range puppetserverTimeLayouts {
+		t, err := time.ParseInLocation(layout, str, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Could not parse time %s", str)
+}
+
+// ParseKeyExport reads the newline delimited json made by the export endpoint. The error is set when the export could
+// not be read.
+func ParseKeyExport(r io.Reader) ([]HieraHostDBLogEntry, ImportResult, error) {
+	res := ImportResult{Errors: []string{}}
+	entries := []HieraHostDBLogEntry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		res.Lines++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e HieraHostDBLogEntry
+		err := json.Unmarshal([]byte(line), &e)
+		if err != nil {
+			res.addError(fmt.Sprintf("line %d: %s", res.Lines, err.Error()))
+			res.Failed++
+			continue
+		}
+		if e.Certname == "" || e.Key == "" {
+			res.addError(fmt.Sprintf("line %d: both certname and key need to be given", res.Lines))
+			res.Failed++
+			continue
+		}
+		res.Lookups++
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, res, fmt.Errorf("line %d could not be read: %s", res.Lines+1, err.Error())
+	}
+	return entries, res, nil
+}
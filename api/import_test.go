@@ -0,0 +1,48 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePuppetserverLog(t *testing.T) {
+	log := strings.Join([]string{
+		"2020-05-11T10:02:13.100+02:00 INFO  [qtp-1] [puppetserver] Compiling catalog for web01.example.com in environment production",
+		"2020-05-11T10:02:13.200+02:00 DEBUG [qtp-1] [puppetserver] Lookup of 'ntp::servers'",
+		"2020-05-11T10:02:13.300+02:00 DEBUG [qtp-1] [puppetserver] Lookup of 'lookup_options'",
+		"2020-05-11T10:02:13.400+02:00 DEBUG [qtp-2] [puppetserver] Lookup of 'ntp::servers'",
+		"2020-05-11T10:02:14.500+02:00 DEBUG [qtp-1] [puppetserver] Automatic Parameter Lookup of 'ntp::servers'",
+		"2020-05-11T10:02:15.000+02:00 INFO  [qtp-1] [puppetserver] Compiled catalog for web01.example.com in environment production in 1.90 seconds",
+		"2020-05-11T10:02:16.000+02:00 INFO  [qtp-2] [puppetserver] Compiled catalog for db01.example.com in environment production in 2.00 seconds",
+	}, "\n") + "\n"
+
+	entries, res, err := ParsePuppetserverLog(strings.NewReader(log), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Lines != 7 || res.Lookups != 3 || res.Unassigned != 0 {
+		t.Errorf("got the result %+v", res)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got the entries %+v", entries)
+	}
+	web := entries[0]
+	if web.Certname != "web01.example.com" || web.Key != "ntp::servers" || web.Count != 2 {
+		t.Errorf("got %+v", web)
+	}
+	if web.FirstSeen == nil || web.Date == nil || !web.seenAtFirst().Before(web.seenAt()) {
+		t.Errorf("the lookups of web01 go from %v to %v", web.FirstSeen, web.Date)
+	}
+	db := entries[1]
+	if db.Certname != "db01.example.com" || db.Count != 1 || db.Environment != "production" {
+		t.Errorf("got %+v", db)
+	}
+}
+
+func TestParseKeyExportTooLong(t *testing.T) {
+	line := "{\"certname\": \"web01\", \"key\": \"" + strings.Repeat("a", 2<<20) + "\"}\n"
+	_, _, err := ParseKeyExport(strings.NewReader("{\"certname\": \"web01\", \"key\": \"foo\"}\n" + line))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+}
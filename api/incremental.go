@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// NodeState is the resolved hierarchy of a certname as it was stored by the last refresh. The hierarchy is only
+// resolved again when the facts of the node or the hiera file changed.
+type NodeState struct {
+	Certname       string    `bson:"_id"`
+	Paths          []string  `bson:"paths"`
+	FactsTimestamp string    `bson:"facts_timestamp"`
+	HierarchyHash  string    `bson:"hierarchy_hash"`
+	Updated        time.Time `bson:"updated"`
+}
+
+// FileKeys are the top level keys of a hiera file as they were stored by the last refresh. The file is only read
+// again when its modification time or size changed.
+type FileKeys struct {
+	Path    string    `bson:"_id"`
+	ModTime time.Time `bson:"mod_time"`
+	Size    int64     `bson:"size"`
+	Keys    []string  `bson:"keys"`
+}
+
+// currentNodeStates returns the hierarchy of every certname. Only the certnames that are new or whose facts or hiera file
+// changed are resolved again, unless full is set. It also returns how many certnames were resolved again.
+func currentNodeStates(ctx context.Context, conf Conf, certnames []string, full bool, job *Job) (map[string]NodeState, int, error) {
+	states, err := GetNodeStates(conf.DB)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	timestamps, err := getFactsTimestamps(conf)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	hierarchyHash := getHierarchyHash(conf)
+	resolve := nodesToResolve(certnames, states, timestamps, hierarchyHash, full)
+	job.AddTotal(len(resolve))
+
+	resolved, err := resolveHierarchyPaths(ctx, conf, resolve, job)
+	if err != nil {
+		return nil, 0, err
+	}
+	updated := []NodeState{}
+	for certname, p := range resolved {
+		state := NodeState{
+			Certname:       certname,
+			Paths:          p,
+			FactsTimestamp: timestamps[certname],
+			HierarchyHash:  hierarchyHash,
+			Updated:        time.Now(),
+		}
+		states[certname] = state
+		updated = append(updated, state)
+	}
+	err = SaveNodeStates(conf.DB, updated)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return states, len(resolved), nil
+}
+
+// nodesToResolve returns the certnames whose hierarchy has to be resolved again. Facts timestamps that are missing
+// because puppetdb could not be reached do not count as a change.
+func nodesToResolve(certnames []string, states map[string]NodeState, timestamps map[string]string, hierarchyHash string, full bool) []string {
+	resolve := []string{}
+	for _, certname := range certnames {
+		state, ok := states[certname]
+		switch {
+		case full || !ok:
+			resolve = append(resolve, certname)
+		case state.HierarchyHash != hierarchyHash:
+			resolve = append(resolve, certname)
+		case timestamps != nil && timestamps[certname] != state.FactsTimestamp:
+			resolve = append(resolve, certname)
+		}
+	}
+	return resolve
+}
+
+// getHierarchyHash returns a hash of the hiera file and the datadir, when either changes every hierarchy has to be
+// resolved again
+func getHierarchyHash(conf Conf) string {
+	h := sha256.New()
+	h.Write([]byte(conf.DataDir))
+	content, err := ioutil.ReadFile(conf.HieraFile)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// getFactsTimestamps returns the time the facts of every node in the facts source were last updated. The cached facts
+// that are older are dropped.
+func getFactsTimestamps(conf Conf) (map[string]string, error) {
+	provider, err := NewFactsProvider(conf)
+	if err != nil {
+		return nil, err
+	}
+	timestamps, err := provider.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	factsCache.Invalidate(timestamps)
+	return timestamps, nil
+}
+
+// getKeysOfFiles returns the top level keys of every path. Files that did not change since the last refresh are not
+// read again, the keys of the files that did change are stored.
+func getKeysOfFiles(d Database, paths []string) map[string][]string {
+	stored, err := GetFileKeys(d)
+	if err != nil {
+		log.Println(err.Error())
+		stored = map[string]FileKeys{}
+	}
+	keys := map[string][]string{}
+	changed := []FileKeys{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		// mongodb stores times in milliseconds
+		modTime := info.ModTime().Truncate(time.Millisecond)
+		if f, ok := stored[p]; ok && f.ModTime.Equal(modTime) && f.Size == info.Size() {
+			keys[p] = f.Keys
+			continue
+		}
+		f := FileKeys{Path: p, ModTime: modTime, Size: info.Size(), Keys: []string{}}
+		for key := range yamlCache.Get(p).Content {
+			f.Keys = append(f.Keys, key)
+		}
+		keys[p] = f.Keys
+		changed = append(changed, f)
+	}
+	if err := SaveFileKeys(d, changed); err != nil {
+		log.Println(err.Error())
+	}
+	return keys
+}
+
+// GetNodeStates gets the stored hierarchy of every certname
+func GetNodeStates(d Database) (map[string]NodeState, error) {
+	states := map[string]NodeState{}
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return states, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	cur, err := dbConn.Database(d.Database).Collection("nodestate").Find(context.TODO(), bson.M{})
+	if err != nil {
+		return states, err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var elem NodeState
+		err := cur.Decode(&elem)
+		if err != nil {
+			log.Println(err.Error())
+		} else {
+			states[elem.Certname] = elem
+		}
+	}
+	return states, cur.Err()
+}
+
+// SaveNodeStates stores the hierarchy of the given certnames
+func SaveNodeStates(d Database, states []NodeState) error {
+	models := []mongo.WriteModel{}
+	for _, s := range states {
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": s.Certname}).SetReplacement(s).SetUpsert(true))
+	}
+	return bulkWrite(d, "nodestate", models)
+}
+
+// GetFileKeys gets the stored keys of every hiera file
+func GetFileKeys(d Database) (map[string]FileKeys, error) {
+	files := map[string]FileKeys{}
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return files, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	cur, err := dbConn.Database(d.Database).Collection("filekeys").Find(context.TODO(), bson.M{})
+	if err != nil {
+		return files, err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var elem FileKeys
+		err := cur.Decode(&elem)
+		if err != nil {
+			log.Println(err.Error())
+		} else {
+			files[elem.Path] = elem
+		}
+	}
+	return files, cur.Err()
+}
+
+// SaveFileKeys stores the keys of the given hiera files
+func SaveFileKeys(d Database, files []FileKeys) error {
+	models := []mongo.WriteModel{}
+	for _, f := range files {
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": f.Path}).SetReplacement(f).SetUpsert(true))
+	}
+	return bulkWrite(d, "filekeys", models)
+}
+
+func bulkWrite(d Database, collection string, models []mongo.WriteModel) error {
+	if len(models) == 0 {
+		return nil
+	}
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	_, err = dbConn.Database(d.Database).Collection(collection).BulkWrite(context.TODO(), models)
+	return err
+}
@@ -12,24 +12,37 @@ import (
 	"time"
 )
 
-func ExportToInfluxDB(c Conf) {
-	CleanAll(c)
+// ExportToInfluxDB refreshes the clean-all and clean-nodes results as part of the job and replaces the influx database
+// with them. The database is only dropped when both refreshes succeeded, a cancelled or failed refresh leaves the last
+// export in place.
+func ExportToInfluxDB(ctx context.Context, c Conf, job *Job) error {
+	if err := CleanAll(ctx, c, TimeWindow{}, false, job); err != nil {
+		return err
+	}
+	if err := RefreshNodeCleanResults(ctx, c, TimeWindow{}, false, job); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	//drop databse
 	query := fmt.Sprintf("DROP DATABASE %s", c.Bucket)
 	c1 := DoRequest(c, query)
 	time.Sleep(2 * time.Second)
 	query = fmt.Sprintf("CREATE DATABASE %s", c.Bucket)
 	c2 := DoRequest(c, query)
-	if c1 && c2 {
-		ExportPerNodeMetrics(c)
-		ExportCleanAllResultMetrics(c)
-		if c.ScanFiles {
-			exportFilesToInfluxDB(c)
-		}
-		if c.ScanClasses {
-			exportUnusedClassesToInflux(c)
-		}
+	if !c1 || !c2 {
+		return fmt.Errorf("The influx database %s could not be recreated", c.Bucket)
+	}
+	ExportPerNodeMetrics(c)
+	ExportCleanAllResultMetrics(c)
+	if c.ScanFiles {
+		exportFilesToInfluxDB(c)
 	}
+	if c.ScanClasses {
+		exportUnusedClassesToInflux(c)
+	}
+	return nil
 }
 
 func exportFilesToInfluxDB(c Conf) {
@@ -127,6 +140,7 @@ func ExportCleanAllResultMetrics(c Conf) {
 	writeApi := client.WriteApiBlocking("", c.Bucket)
 	res, _ := GetFullCleanResultEntry(c.DB)
 	if res != nil {
+		*res = GetIgnoreMatcher(c).FilterCleanAllResult(*res)
 		for _, key := range res.KeysNeverUsed {
 			for _, path := range key.Paths {
 				p := influxdb2.NewPoint("arvo-all-keys-unused",
@@ -156,69 +170,61 @@ func ExportCleanAllResultMetrics(c Conf) {
 
 //func pathsToMapStringInterface(paths []string) {}
 
+// ExportPerNodeMetrics exports the stored clean result of every node
 func ExportPerNodeMetrics(c Conf) {
 	client := influxdb2.NewClient(c.Url, "")
 	writeApi := client.WriteApiBlocking("", c.Bucket)
-	//nodes
-	nodes, err := GetAllCertnameLogEntry(c.DB)
-	if err != nil {
-		log.Println(err.Error())
-
-	} else {
-		for _, n := range nodes {
-			res, _ := CleanUpResultLookupForOneCertname(c, n.ID)
-			if res != nil {
-				/// export the duplicates
-				for _, key := range res.DuplicateData {
-					for _, path := range key.Paths {
-						p := influxdb2.NewPoint("arvo-duplicates",
-							map[string]string{"certname": n.ID, "path": path},
-							map[string]interface{}{"key": key.Key},
-							time.Now())
-						// write point immediately
-						writeApi.WritePoint(context.Background(), p)
-					}
-
-				}
-				/// export in hiera not in log
-				for _, key := range res.InHieraNotInLog {
-					for _, path := range key.Paths {
-						p := influxdb2.NewPoint("arvo-not-log",
-							map[string]string{"certname": n.ID, "path": path},
-							map[string]interface{}{"key": key.Key},
-							time.Now())
-						// write point immediately
-						writeApi.WritePoint(context.Background(), p)
-					}
-
-				}
-				/// export in log and hiera
-				for _, key := range res.InHieraNotInLog {
-					for _, path := range key.Paths {
-						p := influxdb2.NewPoint("arvo-log-hiera",
-							map[string]string{"certname": n.ID, "path": path},
-							map[string]interface{}{"key": key.Key},
-							time.Now())
-						// write point immediately
-						writeApi.WritePoint(context.Background(), p)
-					}
-
-				}
-				/// export in log not in hiera
-				for _, key := range res.InLogNotInHiera {
-					p := influxdb2.NewPoint("arvo-not-hiera",
-						map[string]string{"certname": n.ID},
-						map[string]interface{}{"key": key},
-						time.Now())
-					// write point immediately
-					writeApi.WritePoint(context.Background(), p)
+	matcher := GetIgnoreMatcher(c)
+	err := ForEachNodeCleanResult(c.DB, func(n NodeCleanResult) {
+		res := matcher.FilterCleanResult(n.Certname, n.Result)
+		/// export the duplicates
+		for _, key := range res.DuplicateData {
+			for _, path := range key.Paths {
+				p := influxdb2.NewPoint("arvo-duplicates",
+					map[string]string{"certname": n.Certname, "path": path},
+					map[string]interface{}{"key": key.Key},
+					time.Now())
+				// write point immediately
+				writeApi.WritePoint(context.Background(), p)
+			}
 
-				}
+		}
+		/// export in hiera not in log
+		for _, key := range res.InHieraNotInLog {
+			for _, path := range key.Paths {
+				p := influxdb2.NewPoint("arvo-not-log",
+					map[string]string{"certname": n.Certname, "path": path},
+					map[string]interface{}{"key": key.Key},
+					time.Now())
+				// write point immediately
+				writeApi.WritePoint(context.Background(), p)
+			}
 
+		}
+		/// export in log and hiera
+		for _, key := range res.InLogAndHiera {
+			for _, path := range key.Paths {
+				p := influxdb2.NewPoint("arvo-log-hiera",
+					map[string]string{"certname": n.Certname, "path": path},
+					map[string]interface{}{"key": key.Key},
+					time.Now())
+				// write point immediately
+				writeApi.WritePoint(context.Background(), p)
 			}
 
 		}
+		/// export in log not in hiera
+		for _, key := range res.InLogNotInHiera {
+			p := influxdb2.NewPoint("arvo-not-hiera",
+				map[string]string{"certname": n.Certname},
+				map[string]interface{}{"key": key},
+				time.Now())
+			// write point immediately
+			writeApi.WritePoint(context.Background(), p)
 
+		}
+	})
+	if err != nil {
+		log.Println(err.Error())
 	}
-
 }
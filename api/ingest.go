@@ -0,0 +1,162 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IngestConfig holds the settings for the queue that sits between the keys endpoint and the database
+type IngestConfig struct {
+	QueueSize         int `yaml:"queue_size"`
+	Workers           int `yaml:"workers"`
+	BatchSize         int `yaml:"batch_size"`
+	FlushIntervalMs   int `yaml:"flush_interval_ms"`
+	RetryAfterSeconds int `yaml:"retry_after_seconds"`
+}
+
+// IngestStats are the counters of the ingest queue as they are shown by the metrics endpoint
+type IngestStats struct {
+	QueueDepth    int    `json:"queue_depth"`
+	QueueCapacity int    `json:"queue_capacity"`
+	Enqueued      uint64 `json:"enqueued"`
+	Written       uint64 `json:"written"`
+	Rejected      uint64 `json:"rejected"`
+	Failed        uint64 `json:"failed"`
+	Batches       uint64 `json:"batches"`
+}
+
+// KeyIngester queues logged keys in memory and writes them to the database in batches, so posting a key never waits
+// on the database.
+type KeyIngester struct {
+	conf     Conf
+	queue    chan HieraHostDBLogEntry
+	wg       sync.WaitGroup
+	mu       sync.RWMutex
+	stopped  bool
+	enqueued uint64
+	written  uint64
+	rejected uint64
+	failed   uint64
+	batches  uint64
+}
+
+// NewKeyIngester creates the ingest queue. Call Start to start writing the queued keys.
+func NewKeyIngester(conf Conf) *KeyIngester {
+	return &KeyIngester{
+		conf:  conf,
+		queue: make(chan HieraHostDBLogEntry, conf.Ingest.QueueSize),
+	}
+}
+
+// Start starts the configured amount of workers
+func (k *KeyIngester) Start() {
+	for i := 0; i < k.conf.Ingest.Workers; i++ {
+		k.wg.Add(1)
+		go k.work()
+	}
+}
+
+// Stop closes the queue and waits until all queued keys are written. Keys that are enqueued after it are rejected.
+func (k *KeyIngester) Stop() {
+	k.mu.Lock()
+	if !k.stopped {
+		k.stopped = true
+		close(k.queue)
+	}
+	k.mu.Unlock()
+	k.wg.Wait()
+}
+
+// Enqueue adds a key to the queue without blocking. It returns false when the queue is full or stopped.
+func (k *KeyIngester) Enqueue(e HieraHostDBLogEntry) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.stopped {
+		atomic.AddUint64(&k.rejected, 1)
+		return false
+	}
+	select {
+	case k.queue <- e:
+		atomic.AddUint64(&k.enqueued, 1)
+		return true
+	default:
+		atomic.AddUint64(&k.rejected, 1)
+		return false
+	}
+}
+
+// Stats returns a snapshot of the queue counters
+func (k *KeyIngester) Stats() IngestStats {
+	return IngestStats{
+		QueueDepth:    len(k.queue),
+		QueueCapacity: cap(k.queue),
+		Enqueued:      atomic.LoadUint64(&k.enqueued),
+		Written:       atomic.LoadUint64(&k.written),
+		Rejected:      atomic.LoadUint64(&k.rejected),
+		Failed:        atomic.LoadUint64(&k.failed),
+		Batches:       atomic.LoadUint64(&k.batches),
+	}
+}
+
+func (k *KeyIngester) work() {
+	defer k.wg.Done()
+	ticker := time.NewTicker(time.Duration(k.conf.Ingest.FlushIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+	batch := []HieraHostDBLogEntry{}
+	for {
+		select {
+		case e, ok := <-k.queue:
+			if !ok {
+				k.flush(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= k.conf.Ingest.BatchSize {
+				k.flush(batch)
+				batch = []HieraHostDBLogEntry{}
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				k.flush(batch)
+				batch = []HieraHostDBLogEntry{}
+			}
+		}
+	}
+}
+
+func (k *KeyIngester) flush(batch []HieraHostDBLogEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	atomic.AddUint64(&k.batches, 1)
+	writeErrors, err := BulkUpsertLogEntries(batch, k.conf.DB)
+	if err != nil {
+		log.Println(err.Error())
+		atomic.AddUint64(&k.failed, uint64(len(batch)))
+		return
+	}
+	for _, werr := range writeErrors {
+		log.Println(werr.Error())
+	}
+	atomic.AddUint64(&k.failed, uint64(len(writeErrors)))
+	atomic.AddUint64(&k.written, uint64(len(batch)-len(writeErrors)))
+}
+
+// MetricsEndpoint example
+// @Summary Shows the internal metrics of arvo
+// @Description Shows the depth of the key ingest queue and how many keys were written, rejected because the queue was full or failed to be written. Also shows the clients of the lookup stream and how many lookups were dropped for slow clients, and the hit rate of the facts cache.
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} Metrics ""
+// @Router /metrics [get]
+func MetricsEndpoint(ingester *KeyIngester, stream *KeyStream) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		c.JSON(http.StatusOK, Metrics{Ingest: ingester.Stats(), Stream: stream.Stats(), FactsCache: factsCache.Stats()})
+	}
+	return gin.HandlerFunc(fn)
+}
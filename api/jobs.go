@@ -0,0 +1,331 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The job types
+const (
+	JobCleanAll = "clean-all"
+)
+
+// The states a job can be in
+const (
+	JobRunning   = "running"
+	JobFinished  = "finished"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// maxFinishedJobs is the amount of finished jobs that are remembered
+const maxFinishedJobs = 50
+
+// maxJobErrors is the amount of errors a job keeps, the rest is only counted
+const maxJobErrors = 100
+
+// JobStatus is the state and progress of a job as it is shown by the jobs endpoints
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	State      string     `json:"state"`
+	Done       int        `json:"done"`
+	Total      int        `json:"total"`
+	Started    time.Time  `json:"started"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	Message    string     `json:"message,omitempty"`
+	Errors     []string   `json:"errors"`
+	ErrorCount int        `json:"error_count"`
+}
+
+// Job is a long running task in the background like a clean-all refresh
+type Job struct {
+	ID     string
+	Type   string
+	mu     sync.Mutex
+	status JobStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// JobManager runs the jobs and remembers them. Only one job of every type runs at a time.
+type JobManager struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	running map[string]*Job
+}
+
+// JobRunningError is returned when a job is started while a job of the same type is still running
+type JobRunningError struct {
+	Job *Job
+}
+
+func (e JobRunningError) Error() string {
+	return fmt.Sprintf("A %s job is already running with id %s", e.Job.Type, e.Job.ID)
+}
+
+// NewJobManager creates an empty job manager
+func NewJobManager() *JobManager {
+	return &JobManager{
+		jobs:    map[string]*Job{},
+		running: map[string]*Job{},
+	}
+}
+
+// Start runs the function as a new job in the background. When a job of the same type is running a JobRunningError
+// is returned that holds the running job.
+func (m *JobManager) Start(jobType string, run func(ctx context.Context, job *Job) error) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.running[jobType]; ok {
+		return j, JobRunningError{Job: j}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	id := newJobID()
+	job := &Job{
+		ID:   id,
+		Type: jobType,
+		status: JobStatus{
+			ID:      id,
+			Type:    jobType,
+			State:   JobRunning,
+			Started: time.Now(),
+			Errors:  []string{},
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	m.jobs[job.ID] = job
+	m.running[jobType] = job
+	m.prune()
+
+	go func() {
+		err := runRecovered(func() error { return run(ctx, job) })
+		m.mu.Lock()
+		delete(m.running, jobType)
+		m.mu.Unlock()
+		job.finish(ctx, err)
+		cancel()
+	}()
+	return job, nil
+}
+
+// runRecovered turns a panic of the function into an error, a job runs outside of the gin recovery and would take the
+// whole server down
+func runRecovered(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// Get returns the job with the given id
+func (m *JobManager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	return j, ok
+}
+
+// List returns a snapshot of every remembered job with the newest first
+func (m *JobManager) List() []JobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := []JobStatus{}
+	for _, j := range m.jobs {
+		jobs = append(jobs, j.Snapshot())
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Started.After(jobs[k].Started) })
+	return jobs
+}
+
+// CancelAll cancels every running job and waits until they stopped
+func (m *JobManager) CancelAll() {
+	m.mu.Lock()
+	running := []*Job{}
+	for _, j := range m.running {
+		running = append(running, j)
+	}
+	m.mu.Unlock()
+	for _, j := range running {
+		j.Cancel()
+		j.Wait()
+	}
+}
+
+// prune forgets the oldest finished jobs when there are too many. The lock must be held.
+func (m *JobManager) prune() {
+	finished := []*Job{}
+	for _, j := range m.jobs {
+		if j.Snapshot().State != JobRunning {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i].status.Started.Before(finished[k].status.Started) })
+	for _, j := range finished[:len(finished)-maxFinishedJobs] {
+		delete(m.jobs, j.ID)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Snapshot returns a copy of the status of the job
+func (j *Job) Snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := j.status
+	s.Errors = append([]string{}, j.status.Errors...)
+	return s
+}
+
+// AddTotal adds to the amount of steps the job has to do. It is safe to call on a nil job.
+func (j *Job) AddTotal(steps int) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.status.Total += steps
+	j.mu.Unlock()
+}
+
+// Step marks one step of the job as done. It is safe to call on a nil job.
+func (j *Job) Step() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.status.Done++
+	j.mu.Unlock()
+}
+
+// AddError records an error that did not stop the job. It is safe to call on a nil job.
+func (j *Job) AddError(err error) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.status.ErrorCount++
+	if len(j.status.Errors) < maxJobErrors {
+		j.status.Errors = append(j.status.Errors, err.Error())
+	}
+	j.mu.Unlock()
+}
+
+// Cancel asks the job to stop
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Wait blocks until the job has stopped
+func (j *Job) Wait() {
+	<-j.done
+}
+
+func (j *Job) finish(ctx context.Context, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.status.Finished = &now
+	switch {
+	case ctx.Err() == context.Canceled:
+		j.status.State = JobCancelled
+		j.status.Message = "The job was cancelled"
+	case err != nil:
+		j.status.State = JobFailed
+		j.status.Message = err.Error()
+	default:
+		j.status.State = JobFinished
+	}
+	close(j.done)
+}
+
+// GetJobsEndpoint example
+// @Summary Get the background jobs
+// @Description Lists the running jobs and the last finished ones, newest first.
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []JobStatus
+// @Router /jobs [get]
+func GetJobsEndpoint(jobs *JobManager) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		c.JSON(http.StatusOK, jobs.List())
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// GetJobEndpoint example
+// @Summary Get the state of a background job
+// @Description Shows the state, the progress, the start and end time and the errors of a job.
+// @Param  id     path   string     true  "Some ID"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} JobStatus
+// @Failure 404 {object} APIMessage "The job was not found"
+// @Router /jobs/{id} [get]
+func GetJobEndpoint(jobs *JobManager) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var u1 JSONID
+		c.ShouldBindUri(&u1)
+		defer c.Done()
+		job, ok := jobs.Get(u1.ID)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusOK, job.Snapshot())
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// CancelJobEndpoint example
+// @Summary Cancel a background job
+// @Description Asks a running job to stop. The job stops after the step it is working on.
+// @Param  id     path   string     true  "Some ID"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} APIMessage
+// @Failure 404 {object} APIMessage "The job was not found"
+// @Failure 409 {object} APIMessage "The job is not running"
+// @Router /jobs/{id} [delete]
+func CancelJobEndpoint(jobs *JobManager) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var u1 JSONID
+		c.ShouldBindUri(&u1)
+		defer c.Done()
+		job, ok := jobs.Get(u1.ID)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Job not found"})
+			return
+		}
+		if job.Snapshot().State != JobRunning {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "message": "The job is not running"})
+			return
+		}
+		job.Cancel()
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "The job is being cancelled"})
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -0,0 +1,402 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The lint rules
+const (
+	LintSyntax       = "syntax"
+	LintTabs         = "tabs"
+	LintDuplicateKey = "duplicate_key"
+	LintNamespace    = "namespace"
+	LintValueType    = "value_type"
+	LintEmptyFile    = "empty_file"
+	LintHierarchy    = "hierarchy"
+)
+
+// The severities of a lint rule, a rule that is off does not run
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityOff     = "off"
+)
+
+// defaultLintRules holds every rule with its default severity
+var defaultLintRules = map[string]string{
+	LintSyntax:       SeverityError,
+	LintTabs:         SeverityError,
+	LintDuplicateKey: SeverityError,
+	LintNamespace:    SeverityWarning,
+	LintValueType:    SeverityWarning,
+	LintEmptyFile:    SeverityWarning,
+	LintHierarchy:    SeverityWarning,
+}
+
+// LintConfig changes the severity of the lint rules. Keys in allowed_keys do not need a module namespace,
+// lookup_options and classes never need one.
+type LintConfig struct {
+	Rules       map[string]string `yaml:"rules"`
+	AllowedKeys []string          `yaml:"allowed_keys"`
+}
+
+// LintIssue is one problem in a file of the datadir
+type LintIssue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Path     string `json:"path"`
+	Line     int    `json:"line,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Message  string `json:"message"`
+}
+
+// LintReport holds the issues of every file in the datadir. It is successful when there are no errors.
+type LintReport struct {
+	Success  bool        `json:"success"`
+	Files    int         `json:"files"`
+	Errors   int         `json:"errors"`
+	Warnings int         `json:"warnings"`
+	Issues   []LintIssue `json:"issues"`
+}
+
+// LintEndpoint example
+// @Summary Lint the hiera data
+// @Description Checks every yaml file in the datadir for syntax errors, tabs, duplicate keys, keys without a module namespace, keys with a different type of value in different files, empty files and files that match no level of the hierarchy. The severity of the rules is set in the lint section of the configuration.
+// @Param  rules    query  string     false "Comma separated rules to run, by default all rules that are not off"
+// @Param  severity query  string     false "Only show issues with this severity, error or warning"
+// @Param  path     query  string     false "Only lint the files below this path relative to the datadir"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} LintReport
+// @Failure 400 {object} APIMessage "An unknown rule was given"
+// @Router /lint [get]
+func LintEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		rules, err := LintRules(conf.Lint, c.Query("rules"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		paths := ReadAllFilesYaml(conf)
+		if prefix := c.Query("path"); prefix != "" {
+			paths = filterPathsBelow(conf.DataDir, paths, prefix)
+		}
+		report := LintDatadir(conf, paths, rules)
+		if severity := c.Query("severity"); severity != "" {
+			report.Issues = filterLintIssues(report.Issues, severity)
+		}
+		c.JSON(http.StatusOK, report)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// LintRules returns the severity of every lint rule that runs. The configuration overrides the defaults, only is an
+// optional comma separated list of rules to limit the run to.
+func LintRules(lc LintConfig, only string) (map[string]string, error) {
+	return selectRules(defaultLintRules, lc, only)
+}
+
+// selectRules applies the configuration to the default rules. The configuration holds the lint and the clean rules so
+// both are valid names there.
+func selectRules(defaults map[string]string, lc LintConfig, only string) (map[string]string, error) {
+	rules := map[string]string{}
+	for rule, severity := range defaults {
+		rules[rule] = severity
+	}
+	for rule, severity := range lc.Rules {
+		_, lint := defaultLintRules[rule]
+		_, clean := defaultCleanRules[rule]
+		if !lint && !clean {
+			return nil, fmt.Errorf("unknown rule %s", rule)
+		}
+		if severity != SeverityError && severity != SeverityWarning && severity != SeverityOff {
+			return nil, fmt.Errorf("the severity of rule %s has to be error, warning or off", rule)
+		}
+		if _, ok := defaults[rule]; ok {
+			rules[rule] = severity
+		}
+	}
+	if only != "" {
+		selected := map[string]string{}
+		for _, rule := range strings.Split(only, ",") {
+			rule = strings.TrimSpace(rule)
+			if _, ok := defaults[rule]; !ok {
+				return nil, fmt.Errorf("unknown rule %s", rule)
+			}
+			selected[rule] = rules[rule]
+		}
+		rules = selected
+	}
+	for rule, severity := range rules {
+		if severity == SeverityOff {
+			delete(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func filterPathsBelow(datadir string, paths []string, prefix string) []string {
+	dir := filepath.Join(datadir, prefix)
+	filtered := []string{}
+	for _, p := range paths {
+		if p == dir || insideDir(dir, p) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+func filterLintIssues(issues []LintIssue, severity string) []LintIssue {
+	filtered := []LintIssue{}
+	for _, i := range issues {
+		if i.Severity == severity {
+			filtered = append(filtered, i)
+		}
+	}
+	return filtered
+}
+
+// lintValue is the type of value a file has for a key
+type lintValue struct {
+	path string
+	line int
+	kind string
+}
+
+// LintDatadir runs the rules over the files. The paths in the issues are relative to the datadir.
+func LintDatadir(conf Conf, paths []string, rules map[string]string) LintReport {
+	report := LintReport{Files: len(paths), Issues: []LintIssue{}}
+	add := func(rule string, path string, line int, key string, message string) {
+		report.Issues = append(report.Issues, LintIssue{Rule: rule, Severity: rules[rule], Path: path, Line: line, Key: key, Message: message})
+	}
+	allowed := map[string]bool{"lookup_options": true, "classes": true}
+	for _, k := range conf.Lint.AllowedKeys {
+		allowed[k] = true
+	}
+	var levels []*regexp.Regexp
+	if _, ok := rules[LintHierarchy]; ok {
+		levels = hierarchyLevelPatterns(conf.HieraFile)
+	}
+	values := map[string][]lintValue{}
+
+	for _, p := range paths {
+		// a hiera.yaml inside the datadir is not data
+		if filepath.Clean(p) == filepath.Clean(conf.HieraFile) {
+			report.Files--
+			continue
+		}
+		relative, err := filepath.Rel(conf.DataDir, p)
+		if err != nil {
+			relative = p
+		}
+		relative = filepath.ToSlash(relative)
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			if _, ok := rules[LintSyntax]; ok {
+				add(LintSyntax, relative, 0, "", err.Error())
+			}
+			continue
+		}
+
+		if _, ok := rules[LintTabs]; ok {
+			for i, line := range strings.Split(string(content), "\n") {
+				if strings.TrimLeft(line, " \t") != strings.TrimLeft(line, " ") {
+					add(LintTabs, relative, i+1, "", "tab character in the indentation")
+				} else if strings.Contains(line, "\t") {
+					add(LintTabs, relative, i+1, "", "tab character")
+				}
+			}
+		}
+		if _, ok := rules[LintHierarchy]; ok && len(levels) > 0 && !matchesAnyLevel(levels, relative) {
+			add(LintHierarchy, relative, 0, "", "the file does not match any level of the hierarchy so it is never read")
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			if _, ok := rules[LintSyntax]; ok {
+				add(LintSyntax, relative, yamlErrorLine(err), "", err.Error())
+			}
+			continue
+		}
+		root := &doc
+		if len(doc.Content) > 0 {
+			root = doc.Content[0]
+		}
+		if len(doc.Content) == 0 || root.Tag == "!!null" || (root.Kind == yaml.MappingNode && len(root.Content) == 0) {
+			if _, ok := rules[LintEmptyFile]; ok {
+				add(LintEmptyFile, relative, 0, "", "the file has no keys")
+			}
+			continue
+		}
+		if root.Kind != yaml.MappingNode {
+			if _, ok := rules[LintSyntax]; ok {
+				add(LintSyntax, relative, root.Line, "", "the top level of the file has to be a hash")
+			}
+			continue
+		}
+
+		if _, ok := rules[LintDuplicateKey]; ok {
+			walkYamlNode(root, func(n *yaml.Node) {
+				if n.Kind != yaml.MappingNode {
+					return
+				}
+				seen := map[string]int{}
+				for i := 0; i < len(n.Content); i += 2 {
+					k := n.Content[i]
+					if line, ok := seen[k.Value]; ok {
+						add(LintDuplicateKey, relative, k.Line, k.Value, fmt.Sprintf("%s is already defined on line %d", k.Value, line))
+					} else {
+						seen[k.Value] = k.Line
+					}
+				}
+			})
+		}
+		for i := 0; i < len(root.Content); i += 2 {
+			k, v := root.Content[i], root.Content[i+1]
+			if _, ok := rules[LintNamespace]; ok && !allowed[k.Value] && !strings.Contains(k.Value, "::") {
+				add(LintNamespace, relative, k.Line, k.Value, fmt.Sprintf("%s has no module namespace", k.Value))
+			}
+			if kind := yamlValueKind(v); kind != "" && k.Value != "lookup_options" {
+				values[k.Value] = append(values[k.Value], lintValue{path: relative, line: k.Line, kind: kind})
+			}
+		}
+	}
+
+	if _, ok := rules[LintValueType]; ok {
+		for key, vs := range values {
+			common := mostCommonKind(vs)
+			for _, v := range vs {
+				if v.kind != common {
+					add(LintValueType, v.path, v.line, key, fmt.Sprintf("%s has a value of type %s here but of type %s in most other files", key, v.kind, common))
+				}
+			}
+		}
+	}
+
+	report.summarize()
+	return report
+}
+
+// summarize sorts the issues by file and line and counts them
+func (r *LintReport) summarize() {
+	sort.Slice(r.Issues, func(i, k int) bool {
+		a, b := r.Issues[i], r.Issues[k]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Rule < b.Rule
+	})
+	r.Errors, r.Warnings = 0, 0
+	for _, i := range r.Issues {
+		if i.Severity == SeverityError {
+			r.Errors++
+		} else {
+			r.Warnings++
+		}
+	}
+	r.Success = r.Errors == 0
+}
+
+// hierarchyLevelPatterns turns every path of the hierarchy into a pattern where the interpolations match any file or
+// directory name
+func hierarchyLevelPatterns(hieraFile string) []*regexp.Regexp {
+	var hier HierarchyYamlFile
+	hier.getConf(hieraFile)
+	interpolation := regexp.MustCompile(`%\{[^}]*\}`)
+	patterns := []*regexp.Regexp{}
+	for _, h := range hier.Hierarchy {
+		paths := []string{}
+		if h.Paths != nil {
+			paths = append(paths, *h.Paths...)
+		}
+		if h.Path != nil {
+			paths = append(paths, *h.Path)
+		}
+		for _, p := range paths {
+			parts := interpolation.Split(strings.TrimPrefix(p, "/"), -1)
+			for i := range parts {
+				parts[i] = regexp.QuoteMeta(parts[i])
+			}
+			patterns = append(patterns, regexp.MustCompile("^"+strings.Join(parts, "[^/]+")+"$"))
+		}
+	}
+	return patterns
+}
+
+func matchesAnyLevel(levels []*regexp.Regexp, relative string) bool {
+	for _, l := range levels {
+		if l.MatchString(relative) {
+			return true
+		}
+	}
+	return false
+}
+
+// yamlErrorLine gets the line number from a yaml error
+func yamlErrorLine(err error) int {
+	m := regexp.MustCompile(`line (\d+)`).FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(m[1])
+	return line
+}
+
+// yamlValueKind returns the type of a value. Nulls and aliases to other keys can be any type so they return nothing.
+func yamlValueKind(n *yaml.Node) string {
+	if n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "hash"
+	case yaml.SequenceNode:
+		return "array"
+	case yaml.ScalarNode:
+		switch n.Tag {
+		case "!!null":
+			return ""
+		case "!!str":
+			if strings.HasPrefix(strings.TrimSpace(n.Value), "%{alias(") {
+				return ""
+			}
+			return "string"
+		case "!!int":
+			return "integer"
+		case "!!float":
+			return "float"
+		case "!!bool":
+			return "boolean"
+		}
+		return strings.TrimPrefix(n.Tag, "!!")
+	}
+	return ""
+}
+
+// mostCommonKind returns the type most files use for a key, ties go to the first type alphabetically
+func mostCommonKind(vs []lintValue) string {
+	counts := map[string]int{}
+	for _, v := range vs {
+		counts[v.kind]++
+	}
+	common := ""
+	for kind, n := range counts {
+		if common == "" || n > counts[common] || (n == counts[common] && kind < common) {
+			common = kind
+		}
+	}
+	return common
+}
@@ -17,18 +17,25 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 )
 
 type InLogAndHieraEntry struct {
-	Key   string   `json:"key"yaml:"key"`
-	Paths []string `json:"paths"yaml:"paths"`
+	Key         string   `json:"key"yaml:"key"`
+	Paths       []string `json:"paths"yaml:"paths"`
+	WinningPath string   `json:"winning_path,omitempty" yaml:"winning_path,omitempty"`
+	Owners      []string `json:"owners,omitempty" yaml:"owners,omitempty" bson:"-"`
 }
 
 // GetKeyLocationsForCertnameEndpoint example
 // @Summary Get the clean result for a certname
 // @Description Looks trough you logged entries and hierarchy files to find unused keys etc. That will help you clean up hiera data.
 // @Param  id     path   string     true  "Some ID"
+// @Param  since  query  string     false "Only count keys looked up after this time (RFC3339, date or duration like 30d)"
+// @Param  until  query  string     false "Only count keys looked up before this time (RFC3339, date or duration like 30d)"
+// @Param  ignore query  bool       false "Set to false to show the keys that are hidden by the ignore rules"
 // @Accept  json
 // @Produce  json
 // @Success 200 {object} YamlCleanResult ""
@@ -39,11 +46,20 @@ func GetKeyLocationsForCertnameEndpoint(conf Conf) gin.HandlerFunc {
 		var u1 JSONID
 		c.ShouldBindUri(&u1)
 		defer c.Done()
-		res, err := CleanUpResultLookupForOneCertname(conf, u1.ID)
+		w, err := GetTimeWindowFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		res, err := CleanUpResultLookupForOneCertname(conf, u1.ID, w)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
 
 		} else {
+			if c.Query("ignore") != "false" {
+				*res = GetIgnoreMatcher(conf).FilterCleanResult(u1.ID, *res)
+			}
+			LoadOwners(conf).AnnotateCleanResult(res)
 			c.JSON(http.StatusOK, *res)
 		}
 
@@ -51,175 +67,189 @@ func GetKeyLocationsForCertnameEndpoint(conf Conf) gin.HandlerFunc {
 	return gin.HandlerFunc(fn)
 }
 
-func CleanUpResultLookupForOneCertname(conf Conf, certname string) (*YamlCleanResult, error) {
+// CleanUpResultLookupForOneCertname compares the keys the certname looked up inside the window with the data in its hierarchy
+func CleanUpResultLookupForOneCertname(conf Conf, certname string, w TimeWindow) (*YamlCleanResult, error) {
 	hierarchy, err := GetHierarchyForCertname(conf, certname)
 	if err != nil {
 		return nil, err
-	} else {
-		entries := []YamlMapEntry{}
-
-		for _, p := range hierarchy.Paths {
-			e := GetYamlMapEntryFromPath(p)
-			entries = append(entries, e)
-		}
+	}
+	loggedKeys, err := GetOneCertnameLogEntry(conf.DB, certname, w)
+	if err == ErrEntryNotFound {
+		// no keys inside the window so every key in hiera is unused
+		loggedKeys = &HieraHostDBEntry{ID: certname, Entries: []HieraHostDBLogEntry{}}
+	} else if err != nil {
+		return nil, err
+	}
+	res := CleanUpResultForPaths(conf, hierarchy.Paths, loggedKeys.Entries)
+	return &res, nil
+}
 
-		loggedKeys, err := GetOneCertnameLogEntry(conf.DB, certname)
-		res := YamlCleanResult{
-			InLogNotInHiera: []string{},
-			InLogAndHiera:   []InLogAndHieraEntry{},
-			InHieraNotInLog: []InLogAndHieraEntry{},
-			DuplicateData:   []InLogAndHieraEntry{},
-		}
-		if err == nil {
-			// first get keys in log but not in hiera and in log and in hiera
-			for _, e1 := range loggedKeys.Entries {
-				check0 := false
-				for _, e2 := range entries {
-					if IsKeyInMap(e1.Key, e2.Content) || IsKeyInMap(e1.Key, e2.Flat) {
-						check0 = true
-						check1 := false
-						for index, e3 := range res.InLogAndHiera {
-							if e3.Key == e1.Key {
-								res.InLogAndHiera[index].Paths = append(res.InLogAndHiera[index].Paths, e2.Path)
-								check1 = true
-							}
-						}
-						if !check1 {
-							res.InLogAndHiera = append(res.InLogAndHiera, InLogAndHieraEntry{
-								Key:   e1.Key,
-								Paths: []string{e2.Path},
-							})
-						}
+// CleanUpResultForPaths compares the logged keys of a certname with the data in the paths of its hierarchy
+func CleanUpResultForPaths(conf Conf, paths []string, logged []HieraHostDBLogEntry) YamlCleanResult {
+	entries := []YamlMapEntry{}
+	for _, p := range paths {
+		entries = append(entries, yamlCache.Get(p))
+	}
+	res := YamlCleanResult{
+		InLogNotInHiera: []string{},
+		InLogAndHiera:   []InLogAndHieraEntry{},
+		InHieraNotInLog: []InLogAndHieraEntry{},
+		DuplicateData:   []InLogAndHieraEntry{},
+	}
+	// first get keys in log but not in hiera and in log and in hiera
+	for _, e1 := range logged {
+		check0 := false
+		for _, e2 := range entries {
+			if IsKeyInMap(e1.Key, e2.Content) || IsKeyInMap(e1.Key, e2.Flat) {
+				check0 = true
+				check1 := false
+				for index, e3 := range res.InLogAndHiera {
+					if e3.Key == e1.Key {
+						res.InLogAndHiera[index].Paths = append(res.InLogAndHiera[index].Paths, e2.Path)
+						check1 = true
 					}
 				}
-				if !check0 {
-					res.InLogNotInHiera = append(res.InLogNotInHiera, e1.Key)
+				if !check1 {
+					res.InLogAndHiera = append(res.InLogAndHiera, InLogAndHieraEntry{
+						Key:   e1.Key,
+						Paths: []string{e2.Path},
+					})
 				}
 			}
 		}
-		// now do the reverse
-		for _, e1 := range entries {
-			for key, _ := range e1.Content {
-				if !keyInLog(key, loggedKeys.Entries) {
-					check1 := false
-					for index, e3 := range res.InHieraNotInLog {
-						if e3.Key == key {
-							res.InHieraNotInLog[index].Paths = append(res.InHieraNotInLog[index].Paths, e1.Path)
-							check1 = true
-						}
-					}
-					if !check1 {
-						res.InHieraNotInLog = append(res.InHieraNotInLog, InLogAndHieraEntry{
-							Key:   key,
-							Paths: []string{e1.Path},
-						})
+		if !check0 {
+			res.InLogNotInHiera = append(res.InLogNotInHiera, e1.Key)
+		}
+	}
+	// mark the path the value actually came from when the lookup was logged with its data file
+	for index, e := range res.InLogAndHiera {
+		for _, l := range logged {
+			if l.Key == e.Key && l.File != "" {
+				res.InLogAndHiera[index].WinningPath = MatchProvenancePath(l.File, e.Paths, conf.DataDir)
+			}
+		}
+	}
+	// now do the reverse
+	for _, e1 := range entries {
+		for key, _ := range e1.Content {
+			if !keyInLog(key, logged) {
+				check1 := false
+				for index, e3 := range res.InHieraNotInLog {
+					if e3.Key == key {
+						res.InHieraNotInLog[index].Paths = append(res.InHieraNotInLog[index].Paths, e1.Path)
+						check1 = true
 					}
 				}
+				if !check1 {
+					res.InHieraNotInLog = append(res.InHieraNotInLog, InLogAndHieraEntry{
+						Key:   key,
+						Paths: []string{e1.Path},
+					})
+				}
 			}
 		}
+	}
 
-		// lastly search for duplicates
-		for _, e1 := range entries {
-			for key1, val1 := range e1.Content {
-				for _, e2 := range entries {
-					if e1.Path != e2.Path {
-						for key2, val2 := range e2.Content {
-							check_equal := false
-							switch val1.(type) {
+	// lastly search for duplicates
+	for _, e1 := range entries {
+		for key1, val1 := range e1.Content {
+			for _, e2 := range entries {
+				if e1.Path != e2.Path {
+					for key2, val2 := range e2.Content {
+						check_equal := false
+						switch val1.(type) {
+						case map[string]interface{}:
+							switch val2.(type) {
 							case map[string]interface{}:
-								switch val2.(type) {
-								case map[string]interface{}:
-									if key1 == key2 && reflect.ValueOf(val2).Kind() == reflect.Map {
-										m1 := val1.(map[string]interface{})
-										m2 := val2.(map[string]interface{})
-										eq := reflect.DeepEqual(m1, m2)
-										if eq {
-											check_equal = true
-										}
+								if key1 == key2 && reflect.ValueOf(val2).Kind() == reflect.Map {
+									m1 := val1.(map[string]interface{})
+									m2 := val2.(map[string]interface{})
+									eq := reflect.DeepEqual(m1, m2)
+									if eq {
+										check_equal = true
 									}
-								default:
-									check_equal = false
 								}
+							default:
+								check_equal = false
+							}
+						case []interface{}:
+							switch val2.(type) {
 							case []interface{}:
-								switch val2.(type) {
-								case []interface{}:
-									// if is the same key | standar
-									if key1 == key2 {
-										// If both arrays are the same size
-										if len(val1.([]interface{})) == len(val2.([]interface{})) {
-											a1 := val1.([]interface{})
-											a2 := val2.([]interface{})
-											check_equal = true
-											for indexA, valA := range a1 {
-												t1 := reflect.TypeOf(valA).String()
-												t2 := reflect.TypeOf(a2[indexA]).String()
-												// array of hashes is giving trouble
-												if t1 == t2 {
-													if t1 == "map[interface {}]interface {}" {
-														m1 := valA.(map[interface{}]interface{})
-														m2 := a2[indexA].(map[interface{}]interface{})
-														eq := reflect.DeepEqual(m1, m2)
-														if check_equal {
-															check_equal = eq
-														}
-													} else {
-														if valA != a2[indexA] {
-															check_equal = false
-														}
+								// if is the same key | standar
+								if key1 == key2 {
+									// If both arrays are the same size
+									if len(val1.([]interface{})) == len(val2.([]interface{})) {
+										a1 := val1.([]interface{})
+										a2 := val2.([]interface{})
+										check_equal = true
+										for indexA, valA := range a1 {
+											t1 := reflect.TypeOf(valA).String()
+											t2 := reflect.TypeOf(a2[indexA]).String()
+											// array of hashes is giving trouble
+											if t1 == t2 {
+												if t1 == "map[interface {}]interface {}" {
+													m1 := valA.(map[interface{}]interface{})
+													m2 := a2[indexA].(map[interface{}]interface{})
+													eq := reflect.DeepEqual(m1, m2)
+													if check_equal {
+														check_equal = eq
 													}
 												} else {
-													check_equal = false
+													if valA != a2[indexA] {
+														check_equal = false
+													}
 												}
+											} else {
+												check_equal = false
 											}
-										} else {
-											check_equal = false
 										}
+									} else {
+										check_equal = false
 									}
-								default:
-									check_equal = false
 								}
 							default:
-								if key1 == key2 && val1 == val2 {
-									check_equal = true
-								}
+								check_equal = false
+							}
+						default:
+							if key1 == key2 && val1 == val2 {
+								check_equal = true
 							}
+						}
 
-							if check_equal {
-								check1 := false
-								for index, e3 := range res.DuplicateData {
-									if e3.Key == key1 {
-										if !stringInSlice(e1.Path, res.DuplicateData[index].Paths) {
-											res.DuplicateData[index].Paths = append(res.DuplicateData[index].Paths, e1.Path)
-										}
-										check1 = true
+						if check_equal {
+							check1 := false
+							for index, e3 := range res.DuplicateData {
+								if e3.Key == key1 {
+									if !stringInSlice(e1.Path, res.DuplicateData[index].Paths) {
+										res.DuplicateData[index].Paths = append(res.DuplicateData[index].Paths, e1.Path)
 									}
-								}
-								if !check1 {
-									res.DuplicateData = append(res.DuplicateData, InLogAndHieraEntry{
-										Key:   key1,
-										Paths: []string{e1.Path},
-									})
+									check1 = true
 								}
 							}
+							if !check1 {
+								res.DuplicateData = append(res.DuplicateData, InLogAndHieraEntry{
+									Key:   key1,
+									Paths: []string{e1.Path},
+								})
+							}
 						}
-
 					}
+
 				}
 			}
 		}
-		return &res, nil
-
 	}
-
+	return res
 }
 
 // CleanAllEndpoint example
 // @Summary Returns the clean all result if it has been generated
 // @Description After the resresh function has been done. You can call this method for the result.
+// @Param  ignore query  bool       false "Set to false to show the keys and paths that are hidden by the ignore rules"
 // @Accept  json
 // @Produce  json
 // @Success 200 {object} CleanAllResult "The clean all result."
-// @Failure 404 {object} APIMessage "No entry was found run the /v1/clean-all/refresh endpoint first"
+// @Failure 404 {object} APIMessage "No entry was found, post to the /v1/clean-all/refresh endpoint first"
 // @Failure 500 {object} APIMessage "Something went wrong getting the entries"
 // @Router /clean-all [get]
 func CleanAllEndpoint(conf Conf) gin.HandlerFunc {
@@ -227,13 +257,17 @@ func CleanAllEndpoint(conf Conf) gin.HandlerFunc {
 		res, err := GetFullCleanResultEntry(conf.DB)
 
 		if res == nil {
-			if err != nil {
+			if err != nil && err != ErrEntryNotFound {
 				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
 			} else {
-				c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "No entry was found run the /v1/clean-all/refresh endpoint first"})
+				c.JSON(http.StatusNotFound, gin.H{"success": false, "message": NoCleanAllResultMessage})
 
 			}
 		} else {
+			if c.Query("ignore") != "false" {
+				*res = GetIgnoreMatcher(conf).FilterCleanAllResult(*res)
+			}
+			LoadOwners(conf).AnnotateCleanAllResult(res)
 			c.JSON(http.StatusOK, res)
 
 		}
@@ -243,26 +277,66 @@ func CleanAllEndpoint(conf Conf) gin.HandlerFunc {
 	return fn
 }
 
+// NoCleanAllResultMessage is returned with a 404 when no clean all result is stored yet
+const NoCleanAllResultMessage = "No entry was found, post to the /v1/clean-all/refresh endpoint first"
+
 // CleanAllRefreshEndpoint example
 // @Summary Starts generating an entry for the clean all result.
-// @Description As parsing your whole environment may take a while this job starts doing the process in the background. You will get a json that that says the process has started
+// @Description As parsing your whole environment may take a while this starts a job that does the process in the background. You will get the id of the job, follow it on the jobs endpoint. Only one refresh runs at a time.
+// @Param  since  query  string     false "Only count keys looked up after this time (RFC3339, date or duration like 30d)"
+// @Param  until  query  string     false "Only count keys looked up before this time (RFC3339, date or duration like 30d)"
+// @Param  full   query  bool       false "Resolve the hierarchy of every node again instead of only the changed ones"
 // @Accept  json
 // @Produce  json
-// @Success 200 {object} APIMessage "Gathering result may take a while check the clean endpoint for the result."
-// @Failure 500 {object} APIMessage "Something went wrong getting the entries"
-// @Router /clean-all/refresh [get]
-func CleanAllRefreshEndpoint(conf Conf) gin.HandlerFunc {
+// @Success 202 {object} JobMessage "Gathering result may take a while check the job for its progress."
+// @Failure 409 {object} JobMessage "A refresh is already running"
+// @Router /clean-all/refresh [post]
+func CleanAllRefreshEndpoint(conf Conf, jobs *JobManager) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		go func() {
-			CleanAll(conf)
-		}()
-		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Gathering result may take a while check the clean endpoint for the result."})
+		w, err := GetTimeWindowFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		job, err := StartCleanAllJob(conf, jobs, w, c.Query("full") == "true")
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "message": err.Error(), "job_id": job.ID})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Gathering result may take a while check the job for its progress.", "job_id": job.ID})
 
 	}
 
 	return fn
 }
 
+// StartCleanAllJob starts a clean-all refresh as a job. When a refresh is already running that job is returned together
+// with a JobRunningError.
+func StartCleanAllJob(conf Conf, jobs *JobManager, w TimeWindow, full bool) (*Job, error) {
+	return jobs.Start(JobCleanAll, func(ctx context.Context, job *Job) error {
+		return CleanAll(ctx, conf, w, full, job)
+	})
+}
+
+// MatchProvenancePath returns the candidate path that is the logged data file. The file may be absolute or relative
+// to the datadir.
+func MatchProvenancePath(file string, paths []string, datadir string) string {
+	file = filepath.ToSlash(filepath.Clean(file))
+	relative := strings.TrimPrefix(file, "/")
+	for _, p := range paths {
+		clean := filepath.ToSlash(filepath.Clean(p))
+		if clean == file || clean == filepath.ToSlash(filepath.Join(datadir, relative)) {
+			return p
+		}
+	}
+	for _, p := range paths {
+		if strings.HasSuffix(filepath.ToSlash(p), "/"+relative) {
+			return p
+		}
+	}
+	return ""
+}
+
 func keyInLog(a string, list []HieraHostDBLogEntry) bool {
 	for _, b := range list {
 		if b.Key == a {
@@ -370,75 +444,136 @@ func ReadAllFilesYaml(conf Conf) []string {
 	return yamlFiles
 }
 
-func CleanAll(conf Conf) {
+// CleanAllConfig holds the settings for the clean-all refresh
+type CleanAllConfig struct {
+	Workers             int `yaml:"workers"`
+	PuppetDBConcurrency int `yaml:"puppetdb_concurrency"`
+	History             int `yaml:"history"`
+}
+
+// CleanAll searches for the hiera files and keys that were not used by any certname inside the window and stores the result.
+// The resolved hierarchy of every node is stored, only nodes that are new or whose facts or hiera file changed are
+// resolved again unless full is set. The logged keys are always read from the key log. The hierarchies are resolved by
+// a pool of workers, at most puppetdb_concurrency of them query puppetdb at the same time. The result is sorted so it
+// does not depend on the worker order.
+func CleanAll(ctx context.Context, conf Conf, w TimeWindow, full bool, job *Job) error {
 	paths := ReadAllFilesYaml(conf)
-	paths_matches := []string{}
-	allLoggedHieraKeys := []string{}
-	entries := []YamlMapEntry{}
 	result := CleanAllResult{
 		ID:             "full",
+		Window:         w,
 		PathsNeverUsed: []string{},
 		KeysNeverUsed:  []YamlKeyPath{},
 	}
-	certnameLogEntries, _ := GetAllCertnameLogEntry(conf.DB)
+	certnameLogEntries, err := GetAllCertnameLogEntry(conf.DB, w)
+	if err != nil {
+		return err
+	}
+
+	allLoggedHieraKeys := map[string]bool{}
+	certnames := []string{}
 	for _, k := range certnameLogEntries {
+		certnames = append(certnames, k.ID)
 		for _, key := range k.Entries {
-			if !stringInSlice(key.Key, allLoggedHieraKeys) {
-				allLoggedHieraKeys = append(allLoggedHieraKeys, key.Key)
-			}
+			allLoggedHieraKeys[key.Key] = true
 		}
-		hierarchy, err := GetHierarchyForCertname(conf, k.ID)
-		if err != nil {
-			log.Println(err.Error())
-		} else {
-			for _, p2 := range hierarchy.Paths {
-				if stringInSlice(p2, paths) {
-					if !stringInSlice(p2, paths_matches) {
-						paths_matches = append(paths_matches, p2)
-					}
-				}
-				e := GetYamlMapEntryFromPath(p2)
-				entries = append(entries, e)
-			}
+	}
+
+	states, recomputed, err := currentNodeStates(ctx, conf, certnames, full, job)
+	if err != nil {
+		return err
+	}
+	result.Nodes = len(certnames)
+	result.Recomputed = recomputed
+
+	usedPaths := map[string]bool{}
+	for _, certname := range certnames {
+		for _, p := range states[certname].Paths {
+			usedPaths[p] = true
 		}
 	}
 
 	// these are the files that are in the directory but were never found in the log.
 	for _, p := range paths {
-		if !stringInSlice(p, paths_matches) {
+		if !usedPaths[p] {
 			result.PathsNeverUsed = append(result.PathsNeverUsed, p)
 		}
 	}
+	sort.Strings(result.PathsNeverUsed)
 
 	// this part gets all the keys that never appeared in any log
-	allHieraKeysNotInAnyLogs := []YamlKeyPath{}
-	for _, entry := range entries {
-		for key, _ := range entry.Content {
-			if !stringInSlice(key, allLoggedHieraKeys) {
-				check := false
-				for i, e := range allHieraKeysNotInAnyLogs {
-					if e.Key == key {
-						check = true
-						if !stringInSlice(entry.Path, e.Paths) {
-							allHieraKeysNotInAnyLogs[i].Paths = append(allHieraKeysNotInAnyLogs[i].Paths, entry.Path)
-						}
-					}
-				}
-				if !check {
-					allHieraKeysNotInAnyLogs = append(allHieraKeysNotInAnyLogs, YamlKeyPath{
-						Paths: []string{entry.Path},
-						Key:   key,
-					})
-				}
+	sortedPaths := []string{}
+	for p := range usedPaths {
+		sortedPaths = append(sortedPaths, p)
+	}
+	sort.Strings(sortedPaths)
+	fileKeys := getKeysOfFiles(conf.DB, sortedPaths)
+	keyPaths := map[string][]string{}
+	for _, p := range sortedPaths {
+		for _, key := range fileKeys[p] {
+			if !allLoggedHieraKeys[key] && !IsReservedHieraKey(key) {
+				keyPaths[key] = append(keyPaths[key], p)
 			}
 		}
 	}
 	// this is the result of the keys that are in some yaml file but never appeared in any log.
-	for _, e := range allHieraKeysNotInAnyLogs {
-		result.KeysNeverUsed = append(result.KeysNeverUsed, e)
+	for key, p := range keyPaths {
+		result.KeysNeverUsed = append(result.KeysNeverUsed, YamlKeyPath{Key: key, Paths: p})
+	}
+	sort.Slice(result.KeysNeverUsed, func(i, k int) bool { return result.KeysNeverUsed[i].Key < result.KeysNeverUsed[k].Key })
+
+	_, err = InsertFullCleanResultWrapper(result, conf)
+	if err != nil {
+		return err
 	}
-	InsertFullCleanResultWrapper(result, conf)
+	return InsertCleanAllSnapshot(conf.DB, result, conf.CleanAll.History)
+}
 
+// resolveHierarchyPaths resolves the hierarchy of every certname with a pool of workers and returns the paths of every
+// hierarchy that could be resolved. Certnames whose hierarchy can not be resolved are added to the errors of the job.
+func resolveHierarchyPaths(ctx context.Context, conf Conf, certnames []string, job *Job) (map[string][]string, error) {
+	queue := make(chan string)
+	puppetdbSlots := make(chan struct{}, conf.CleanAll.PuppetDBConcurrency)
+	var mu sync.Mutex
+	resolved := map[string][]string{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < conf.CleanAll.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for certname := range queue {
+				var hierarchy *HierarchyResult
+				err := runRecovered(func() error {
+					puppetdbSlots <- struct{}{}
+					defer func() { <-puppetdbSlots }()
+					var err error
+					hierarchy, err = GetHierarchyForCertname(conf, certname)
+					return err
+				})
+				if err != nil {
+					log.Println(err.Error())
+					job.AddError(fmt.Errorf("%s: %s", certname, err.Error()))
+				} else {
+					mu.Lock()
+					resolved[certname] = hierarchy.Paths
+					mu.Unlock()
+				}
+				job.Step()
+			}
+		}()
+	}
+
+	var err error
+	for _, certname := range certnames {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+		queue <- certname
+	}
+	close(queue)
+	wg.Wait()
+	return resolved, err
 }
 
 func InsertFullCleanResultWrapper(e CleanAllResult, d Conf) (*string, error) {
@@ -473,6 +608,10 @@ func GetFullCleanResultEntry(d Database) (*CleanAllResult, error) {
 	var result *CleanAllResult
 	// Finding multiple documents returns a cursor
 	cur, err := collection.Find(context.TODO(), filter, findOptions)
+	if err != nil {
+		dbConn.Disconnect(context.TODO())
+		return nil, err
+	}
 	for cur.Next(context.TODO()) {
 		var elem CleanAllResult
 		err := cur.Decode(&elem)
@@ -490,7 +629,7 @@ func GetFullCleanResultEntry(d Database) (*CleanAllResult, error) {
 	if result != nil {
 		return result, nil
 	}
-	return nil, errors.New("Entry not found")
+	return nil, ErrEntryNotFound
 }
 
 func InsertFullCleanResult(e CleanAllResult, d Database) (*string, error) {
@@ -510,7 +649,7 @@ func InsertFullCleanResult(e CleanAllResult, d Database) (*string, error) {
 }
 
 func UpdateFullCleanResult(e CleanAllResult, d Database) (*string, error) {
-	ks, _ := GetOneCertnameLogEntry(d, e.ID)
+	ks, _ := GetFullCleanResultEntry(d)
 	if ks == nil {
 		return nil, errors.New("Entry not found")
 	}
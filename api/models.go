@@ -8,6 +8,7 @@ import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"log"
+	"time"
 )
 
 var LAYOUT = "2006-01-02T15:04:05-0700"
@@ -17,6 +18,13 @@ type APIMessage struct {
 	Message string
 }
 
+// JobMessage is the answer of an endpoint that starts a job
+type JobMessage struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	JobID   string `json:"job_id"`
+}
+
 type APIArrayMessage struct {
 	Success bool
 	Message []string
@@ -31,20 +39,31 @@ type HieraDataExample struct {
 
 // Conf is the main configuration file for arvo and holds the settings needed to run it
 type Conf struct {
-	DB             Database       `yaml:"db"`
-	KeyTTLMinutes  int            `yaml:"key_ttl_minutes"`
-	DataDir        string         `yaml:"datadir"`
-	Puppet         PuppetDBConfig `yaml:"puppet"`
-	HieraFile      string         `yaml:"hiera_file"`
-	Hierarchy      []string       `yaml:"hierarchy"`
-	CodeDir        string         `yaml:"codedir"`
-	PuppetEnv      string         `yaml:"env"`
-	ScanFiles      bool           `yaml:"scan_files"`
-	ScanClasses    bool           `yaml:"scan_classes"`
-	UseInflux      bool           `yaml:"use_influx"`
-	Url            string         `yaml:"url"`
-	Bucket         string         `yaml:"bucket"`
-	InfluxInterval int            `yaml:"influx_interval"`
+	DB             Database        `yaml:"db"`
+	KeyTTLMinutes  int             `yaml:"key_ttl_minutes"`
+	KeyRetention   int             `yaml:"key_retention_days"`
+	DataDir        string          `yaml:"datadir"`
+	Puppet         PuppetDBConfig  `yaml:"puppet"`
+	HieraFile      string          `yaml:"hiera_file"`
+	Hierarchy      []string        `yaml:"hierarchy"`
+	CodeDir        string          `yaml:"codedir"`
+	PuppetEnv      string          `yaml:"env"`
+	ScanFiles      bool            `yaml:"scan_files"`
+	ScanClasses    bool            `yaml:"scan_classes"`
+	UseInflux      bool            `yaml:"use_influx"`
+	Url            string          `yaml:"url"`
+	Bucket         string          `yaml:"bucket"`
+	InfluxInterval int             `yaml:"influx_interval"`
+	Ingest         IngestConfig    `yaml:"ingest"`
+	StreamBuffer   int             `yaml:"stream_buffer_size"`
+	ImportMaxMB    int             `yaml:"import_max_mb"`
+	Reconcile      ReconcileConfig `yaml:"reconcile"`
+	Schedule       ScheduleConfig  `yaml:"schedule"`
+	CleanAll       CleanAllConfig  `yaml:"clean_all"`
+	OwnersFile     string          `yaml:"owners_file"`
+	Git            GitConfig       `yaml:"git"`
+	Lint           LintConfig      `yaml:"lint"`
+	Facts          FactsConfig     `yaml:"facts"`
 }
 
 // Database holds the database settings to run arvo
@@ -59,6 +78,15 @@ type Database struct {
 	connection   mongo.Client
 }
 
+// KeyRetentionDuration returns how long logged keys are kept. The deprecated key_ttl_minutes is only used when no
+// key_retention_days is set.
+func (c Conf) KeyRetentionDuration() time.Duration {
+	if c.KeyRetention > 0 {
+		return time.Duration(c.KeyRetention) * 24 * time.Hour
+	}
+	return time.Duration(c.KeyTTLMinutes) * time.Minute
+}
+
 // GetConf is a function that reads in data from a yaml file into a Conf object
 func (c *Conf) GetConf(configFile string) *Conf {
 
@@ -68,7 +96,7 @@ func (c *Conf) GetConf(configFile string) *Conf {
 	}
 	err = yaml.Unmarshal(yamlFile, c)
 	if err != nil {
-		log.Println("Unmarshal: %v", err)
+		log.Printf("Unmarshal: %v", err)
 	}
 
 	return c
@@ -120,15 +148,42 @@ func (c *HierarchyYamlFile) getConf(configFile string) {
 	}
 	err = yaml.Unmarshal(yamlFile, c)
 	if err != nil {
-		log.Println("Unmarshal: %v", err)
+		log.Printf("Unmarshal: %v", err)
 	}
 }
 
 // HieraHostDBLogEntry is a single entry the hiera-log makes. So it is just a lookup for a key for a certname
 type HieraHostDBLogEntry struct {
-	Certname string  `json:"certname"`
-	Key      string  `json:"key"`
-	Date     *string `json:"date_string"`
+	Certname    string  `json:"certname"`
+	Key         string  `json:"key"`
+	Date        *string `json:"date_string"`
+	FirstSeen   *string `json:"first_seen,omitempty" bson:"-"`
+	Count       int64   `json:"count,omitempty" bson:"-"`
+	RunID       string  `json:"run_id,omitempty" bson:"-"`
+	Environment string  `json:"environment,omitempty" bson:"-"`
+	Level       string  `json:"level,omitempty" bson:"-"`
+	File        string  `json:"file,omitempty" bson:"-"`
+	ValueHash   string  `json:"value_hash,omitempty" bson:"-"`
+	// lookups is the amount of lookups the entry stands for once duplicates in a bulk write are merged
+	lookups int64
+}
+
+// TimeWindow limits the logged keys to the ones looked up between since and until. Both ends are optional.
+type TimeWindow struct {
+	Since *time.Time `json:"since,omitempty" bson:"since,omitempty"`
+	Until *time.Time `json:"until,omitempty" bson:"until,omitempty"`
+}
+
+// HieraKeyLogRecord is how a logged key is stored in the database. There is exactly one record per certname and key.
+type HieraKeyLogRecord struct {
+	Certname  string    `bson:"certname" json:"certname"`
+	Key       string    `bson:"key" json:"key"`
+	FirstSeen time.Time `bson:"first_seen" json:"first_seen"`
+	LastSeen  time.Time `bson:"last_seen" json:"last_seen"`
+	Count     int64     `bson:"count" json:"count"`
+	Level     string    `bson:"level,omitempty" json:"level,omitempty"`
+	File      string    `bson:"file,omitempty" json:"file,omitempty"`
+	ValueHash string    `bson:"value_hash,omitempty" json:"value_hash,omitempty"`
 }
 
 // HieraHostDBEntry is just a collection ok key entries that have been looked up for a specific host.
@@ -137,6 +192,56 @@ type HieraHostDBEntry struct {
 	Entries []HieraHostDBLogEntry `json:"keys"`
 }
 
+// MaxKeyBatchSize is the maximum amount of lookups that can be posted in one batch
+const MaxKeyBatchSize = 50000
+
+// MaxKeyBatchBytes is the maximum size of the body of a batch
+const MaxKeyBatchBytes = 32 << 20
+
+// KeyBatchItemResult is the result for one lookup posted to the batch endpoint. The index is its position in the posted batch.
+type KeyBatchItemResult struct {
+	Index    int    `json:"index"`
+	Certname string `json:"certname"`
+	Key      string `json:"key"`
+	Success  bool   `json:"success"`
+	Message  string `json:"message,omitempty"`
+}
+
+// KeyBatchResult is returned by the batch endpoint and holds the result of every posted lookup
+type KeyBatchResult struct {
+	Success  bool                 `json:"success"`
+	Accepted int                  `json:"accepted"`
+	Failed   int                  `json:"failed"`
+	Results  []KeyBatchItemResult `json:"results"`
+}
+
+// Metrics holds the internal counters of arvo
+type Metrics struct {
+	Ingest     IngestStats     `json:"ingest"`
+	Stream     StreamStats     `json:"stream"`
+	FactsCache FactsCacheStats `json:"facts_cache"`
+}
+
+// HieraRun is one catalog compile of a certname with all the keys that were looked up during it
+type HieraRun struct {
+	ID          string    `bson:"_id" json:"-"`
+	RunID       string    `bson:"run_id" json:"run_id"`
+	Certname    string    `bson:"certname" json:"certname"`
+	Environment string    `bson:"environment" json:"environment"`
+	FirstSeen   time.Time `bson:"first_seen" json:"first_seen"`
+	LastSeen    time.Time `bson:"last_seen" json:"last_seen"`
+	Keys        []string  `bson:"keys" json:"keys"`
+}
+
+// HieraRunDiff shows which keys were looked up in the to run but not in the from run and the other way around
+type HieraRunDiff struct {
+	Certname string   `json:"certname"`
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+}
+
 // HierarchyResult is an object that is used to return data in json form trough the api. It holds the result for which hierarchy was found and which variables
 type HierarchyResult struct {
 	Paths     []string `json:"paths"yaml:"paths"`
@@ -186,15 +291,23 @@ type YamlCleanResult struct {
 	InLogAndHiera   []InLogAndHieraEntry `json:"in_log_and_hiera"yaml:"in_log_and_hiera"`
 	InHieraNotInLog []InLogAndHieraEntry `json:"in_hiera_not_in_log"yaml:"in_hiera_not_in_log"`
 	DuplicateData   []InLogAndHieraEntry `json:"duplicates"yaml:"duplicates"`
+	// InLogNotInHieraOwners holds the owners of the keys in InLogNotInHiera that match a key pattern of the owners file
+	InLogNotInHieraOwners map[string][]string `json:"in_log_not_in_hiera_owners,omitempty" yaml:"in_log_not_in_hiera_owners,omitempty" bson:"-"`
 }
 
 type CleanAllResult struct {
 	ID             string        `bson:"_id"json:"id"`
+	Window         TimeWindow    `json:"window" yaml:"window"`
 	PathsNeverUsed []string      `json:"paths_never_used"yaml:"paths_never_used"`
 	KeysNeverUsed  []YamlKeyPath `json:"keys_never_used"yaml:"keys_never_used"`
+	Nodes          int           `json:"nodes" yaml:"nodes"`
+	Recomputed     int           `json:"recomputed" yaml:"recomputed"`
+	// PathOwners holds the owners of the paths in PathsNeverUsed
+	PathOwners map[string][]string `json:"path_owners,omitempty" yaml:"path_owners,omitempty" bson:"-"`
 }
 
 type YamlKeyPath struct {
-	Paths []string `json:"paths"yaml:"paths"`
-	Key   string   `json:"key"yaml:"key"`
+	Paths  []string `json:"paths"yaml:"paths"`
+	Key    string   `json:"key"yaml:"key"`
+	Owners []string `json:"owners,omitempty" yaml:"owners,omitempty" bson:"-"`
 }
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// JobCleanNodes is the job type of the refresh of the per node clean results
+const JobCleanNodes = "clean-nodes"
+
+// maxNodeCleanPageSize is the largest page the node clean query returns
+const maxNodeCleanPageSize = 500
+
+// NodeCleanCounts are the amount of keys in every category of a node clean result
+type NodeCleanCounts struct {
+	InLogNotInHiera int `bson:"in_log_not_in_hiera" json:"in_log_not_in_hiera"`
+	InLogAndHiera   int `bson:"in_log_and_hiera" json:"in_log_and_hiera"`
+	InHieraNotInLog int `bson:"in_hiera_not_in_log" json:"in_hiera_not_in_log"`
+	Duplicates      int `bson:"duplicates" json:"duplicates"`
+}
+
+// NodeCleanResult is the stored clean result of one certname
+type NodeCleanResult struct {
+	Certname string          `bson:"_id" json:"certname"`
+	Updated  time.Time       `bson:"updated" json:"updated"`
+	Window   TimeWindow      `bson:"window" json:"window"`
+	Counts   NodeCleanCounts `bson:"counts" json:"counts"`
+	Result   YamlCleanResult `bson:"result" json:"result"`
+}
+
+// NodeCleanPage is one page of stored node clean results
+type NodeCleanPage struct {
+	Total   int64             `json:"total"`
+	Page    int               `json:"page"`
+	PerPage int               `json:"per_page"`
+	Nodes   []NodeCleanResult `json:"nodes"`
+}
+
+// nodeCleanCategories maps the categories of a clean result to the stored field that holds the keys and the stored
+// count. The fields of YamlCleanResult have no bson tags so they are stored lowercased.
+var nodeCleanCategories = map[string]struct{ keyField, countField string }{
+	"in_log_not_in_hiera": {"result.inlognotinhiera", "counts.in_log_not_in_hiera"},
+	"in_log_and_hiera":    {"result.inlogandhiera.key", "counts.in_log_and_hiera"},
+	"in_hiera_not_in_log": {"result.inhieranotinlog.key", "counts.in_hiera_not_in_log"},
+	"duplicates":          {"result.duplicatedata.key", "counts.duplicates"},
+}
+
+// NodeCleanRefreshEndpoint example
+// @Summary Starts storing the clean result of every node
+// @Description Starts a job that computes the clean result of every logged certname and every active node and stores it. Follow the job on the jobs endpoint. Only one refresh runs at a time.
+// @Param  since  query  string     false "Only count keys looked up after this time (RFC3339, date or duration like 30d)"
+// @Param  until  query  string     false "Only count keys looked up before this time (RFC3339, date or duration like 30d)"
+// @Param  full   query  bool       false "Resolve the hierarchy of every node again instead of only the changed ones"
+// @Accept  json
+// @Produce  json
+// @Success 202 {object} JobMessage "Gathering result may take a while check the job for its progress."
+// @Failure 409 {object} JobMessage "A refresh is already running"
+// @Router /clean-nodes/refresh [post]
+func NodeCleanRefreshEndpoint(conf Conf, jobs *JobManager) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		w, err := GetTimeWindowFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		job, err := StartCleanNodesJob(conf, jobs, w, c.Query("full") == "true")
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "message": err.Error(), "job_id": job.ID})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Gathering result may take a while check the job for its progress.", "job_id": job.ID})
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// NodeCleanQueryEndpoint example
+// @Summary Query the stored clean results of the nodes
+// @Description Finds the nodes whose stored clean result has the key in the category, or more than the given amount of keys in the category. Without filters every node is returned. The nodes are sorted by certname. The filters and counts are applied to the stored results before the ignore rules.
+// @Param  category   query  string     false "in_log_not_in_hiera, in_log_and_hiera, in_hiera_not_in_log or duplicates"
+// @Param  key        query  string     false "Only nodes that have this key in the category"
+// @Param  more_than  query  int        false "Only nodes with more than this amount of keys in the category"
+// @Param  page       query  int        false "The page, starting at 1"
+// @Param  per_page   query  int        false "The amount of nodes per page, 50 by default"
+// @Param  ignore     query  bool       false "Set to false to show the keys that are hidden by the ignore rules"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} NodeCleanPage
+// @Failure 400 {object} APIMessage "Invalid parameters"
+// @Failure 500 {object} APIMessage "Something went wrong getting the results"
+// @Router /clean-nodes [get]
+func NodeCleanQueryEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		filter, err := nodeCleanFilter(c.Query("category"), c.Query("key"), c.Query("more_than"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		page, perPage, err := getPageFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		res, err := QueryNodeCleanResults(conf.DB, filter, page, perPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		m := GetIgnoreMatcher(conf)
+		owners := LoadOwners(conf)
+		for i, n := range res.Nodes {
+			if c.Query("ignore") != "false" {
+				res.Nodes[i].Result = m.FilterCleanResult(n.Certname, n.Result)
+			}
+			owners.AnnotateCleanResult(&res.Nodes[i].Result)
+		}
+		c.JSON(http.StatusOK, res)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// NodeCleanEndpoint example
+// @Summary Get the stored clean result of a node
+// @Description Shows the clean result of a certname as it was stored by the last refresh of the node clean results.
+// @Param  id     path   string     true  "Some ID"
+// @Param  ignore query  bool       false "Set to false to show the keys that are hidden by the ignore rules"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} NodeCleanResult
+// @Failure 404 {object} APIMessage "No result was stored for the node"
+// @Router /clean-nodes/{id} [get]
+func NodeCleanEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var u1 JSONID
+		c.ShouldBindUri(&u1)
+		defer c.Done()
+		res, err := QueryNodeCleanResults(conf.DB, bson.M{"_id": u1.ID}, 1, 1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		if len(res.Nodes) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Entry not found"})
+			return
+		}
+		node := res.Nodes[0]
+		if c.Query("ignore") != "false" {
+			node.Result = GetIgnoreMatcher(conf).FilterCleanResult(node.Certname, node.Result)
+		}
+		LoadOwners(conf).AnnotateCleanResult(&node.Result)
+		c.JSON(http.StatusOK, node)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// nodeCleanFilter builds the query on the stored results. more_than and key need a category.
+func nodeCleanFilter(category string, key string, moreThan string) (bson.M, error) {
+	filter := bson.M{}
+	if category == "" {
+		if key != "" || moreThan != "" {
+			return nil, errors.New("A category is needed to filter on key or more_than")
+		}
+		return filter, nil
+	}
+	fields, ok := nodeCleanCategories[category]
+	if !ok {
+		return nil, fmt.Errorf("Unknown category %s", category)
+	}
+	if key != "" {
+		filter[fields.keyField] = key
+	}
+	if moreThan != "" {
+		n, err := strconv.Atoi(moreThan)
+		if err != nil {
+			return nil, fmt.Errorf("more_than must be a number")
+		}
+		filter[fields.countField] = bson.M{"$gt": n}
+	}
+	return filter, nil
+}
+
+// getPageFromQuery reads the page and per_page parameters
+func getPageFromQuery(c *gin.Context) (int, int, error) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		return 0, 0, errors.New("page must be a number of at least 1")
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", "50"))
+	if err != nil || perPage < 1 || perPage > maxNodeCleanPageSize {
+		return 0, 0, fmt.Errorf("per_page must be a number between 1 and %d", maxNodeCleanPageSize)
+	}
+	return page, perPage, nil
+}
+
+// StartCleanNodesJob starts a refresh of the node clean results as a job. When a refresh is already running that job is
+// returned together with a JobRunningError.
+func StartCleanNodesJob(conf Conf, jobs *JobManager, w TimeWindow, full bool) (*Job, error) {
+	return jobs.Start(JobCleanNodes, func(ctx context.Context, job *Job) error {
+		return RefreshNodeCleanResults(ctx, conf, w, full, job)
+	})
+}
+
+// RefreshNodeCleanResults computes and stores the clean result of every logged certname. The stored hierarchies are
+// reused like the clean-all refresh does. Results of certnames that no longer have logged keys are removed.
+func RefreshNodeCleanResults(ctx context.Context, conf Conf, w TimeWindow, full bool, job *Job) error {
+	hosts, err := GetAllCertnameLogEntry(conf.DB, w)
+	if err != nil {
+		return err
+	}
+	certnames := []string{}
+	logged := map[string]bool{}
+	for _, h := range hosts {
+		certnames = append(certnames, h.ID)
+		logged[h.ID] = true
+	}
+	// active nodes without keys in the window have every key unused, the same as v1/clean/:id shows for them
+	active, err := GetActiveCertnames(conf)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	for _, certname := range active {
+		if !logged[certname] {
+			certnames = append(certnames, certname)
+			hosts = append(hosts, HieraHostDBEntry{ID: certname, Entries: []HieraHostDBLogEntry{}})
+		}
+	}
+	job.AddTotal(len(hosts))
+	states, _, err := currentNodeStates(ctx, conf, certnames, full, job)
+	if err != nil {
+		return err
+	}
+
+	dbConn, err := NewClient(conf.DB)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	collection := dbConn.Database(conf.DB.Database).Collection("nodeclean")
+	models := []mongo.WriteModel{}
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		_, err := collection.BulkWrite(context.TODO(), models, options.BulkWrite().SetOrdered(false))
+		models = []mongo.WriteModel{}
+		return err
+	}
+	for _, h := range hosts {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		state, ok := states[h.ID]
+		if !ok {
+			// the hierarchy could not be resolved, the error is already on the job
+			job.Step()
+			continue
+		}
+		res := CleanUpResultForPaths(conf, state.Paths, h.Entries)
+		node := NodeCleanResult{
+			Certname: h.ID,
+			Updated:  time.Now(),
+			Window:   w,
+			Counts: NodeCleanCounts{
+				InLogNotInHiera: len(res.InLogNotInHiera),
+				InLogAndHiera:   len(res.InLogAndHiera),
+				InHieraNotInLog: len(res.InHieraNotInLog),
+				Duplicates:      len(res.DuplicateData),
+			},
+			Result: res,
+		}
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": h.ID}).SetReplacement(node).SetUpsert(true))
+		if len(models) >= 100 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		job.Step()
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	// without the active nodes it is unknown which nodes are gone, so nothing is deleted
+	if len(active) == 0 {
+		return nil
+	}
+	_, err = collection.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$nin": certnames}})
+	return err
+}
+
+// QueryNodeCleanResults gets one page of the stored node clean results that match the filter, sorted by certname
+func QueryNodeCleanResults(d Database, filter bson.M, page int, perPage int) (*NodeCleanPage, error) {
+	res := NodeCleanPage{Page: page, PerPage: perPage, Nodes: []NodeCleanResult{}}
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return nil, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	collection := dbConn.Database(d.Database).Collection("nodeclean")
+	res.Total, err = collection.CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, err
+	}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(int64((page - 1) * perPage)).
+		SetLimit(int64(perPage))
+	cur, err := collection.Find(context.TODO(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var elem NodeCleanResult
+		err := cur.Decode(&elem)
+		if err != nil {
+			log.Println(err.Error())
+		} else {
+			res.Nodes = append(res.Nodes, elem)
+		}
+	}
+	return &res, cur.Err()
+}
+
+// ForEachNodeCleanResult calls fn with every stored node clean result
+func ForEachNodeCleanResult(d Database, fn func(NodeCleanResult)) error {
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	cur, err := dbConn.Database(d.Database).Collection("nodeclean").Find(context.TODO(), bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var elem NodeCleanResult
+		err := cur.Decode(&elem)
+		if err != nil {
+			log.Println(err.Error())
+		} else {
+			fn(elem)
+		}
+	}
+	return cur.Err()
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The rules of the offline clean
+const (
+	CleanUnusedFile = "unused_file"
+	CleanUnusedKey  = "unused_key"
+	CleanDuplicate  = "duplicate"
+)
+
+// defaultCleanRules holds every clean rule with its default severity
+var defaultCleanRules = map[string]string{
+	CleanUnusedFile: SeverityWarning,
+	CleanUnusedKey:  SeverityWarning,
+	CleanDuplicate:  SeverityWarning,
+}
+
+// CleanRules returns the severity of every clean rule that runs, like LintRules does for the lint rules
+func CleanRules(lc LintConfig, only string) (map[string]string, error) {
+	return selectRules(defaultCleanRules, lc, only)
+}
+
+// CleanDatadir does the clean analysis without puppetdb and mongodb. The hierarchy of every node is resolved with the
+// given facts. It reports the files that no node reads, the keys in those hierarchies that were never looked up and the
+// keys that have the same value in several files of the hierarchy of a node. The unused keys are only checked when
+// lookups are given.
+func CleanDatadir(conf Conf, nodes map[string]map[string]interface{}, logged []HieraHostDBLogEntry, rules map[string]string) LintReport {
+	files := []string{}
+	for _, p := range ReadAllFilesYaml(conf) {
+		if filepath.Clean(p) != filepath.Clean(conf.HieraFile) {
+			files = append(files, filepath.Clean(p))
+		}
+	}
+	report := LintReport{Files: len(files), Issues: []LintIssue{}}
+	lines := map[string]map[string]int{}
+	add := func(rule string, path string, key string, message string) {
+		if lines[path] == nil {
+			lines[path] = yamlKeyLines(path)
+		}
+		report.Issues = append(report.Issues, LintIssue{
+			Rule:     rule,
+			Severity: rules[rule],
+			Path:     relativeToDatadir(conf.DataDir, path),
+			Line:     lines[path][key],
+			Key:      key,
+			Message:  message,
+		})
+	}
+
+	certnames := []string{}
+	for certname := range nodes {
+		certnames = append(certnames, certname)
+	}
+	sort.Strings(certnames)
+	h := GetPathsAndVarsInHierarchy(conf)
+	usedPaths := map[string]bool{}
+	duplicates := map[string]*InLogAndHieraEntry{}
+	duplicateNodes := map[string]int{}
+	for _, certname := range certnames {
+		facts := nodes[certname]
+		if _, ok := facts["environment"]; !ok {
+			facts["environment"] = conf.PuppetEnv
+		}
+		paths := []string{}
+		for _, p := range ResolveHierarchy(h, facts).Paths {
+			paths = append(paths, filepath.Clean(p))
+			usedPaths[filepath.Clean(p)] = true
+		}
+		if _, ok := rules[CleanDuplicate]; !ok {
+			continue
+		}
+		for _, d := range CleanUpResultForPaths(conf, paths, nil).DuplicateData {
+			sort.Strings(d.Paths)
+			id := d.Key + "\x00" + strings.Join(d.Paths, "\x00")
+			if _, ok := duplicates[id]; !ok {
+				entry := d
+				duplicates[id] = &entry
+			}
+			duplicateNodes[id]++
+		}
+	}
+
+	if _, ok := rules[CleanUnusedFile]; ok {
+		for _, p := range files {
+			if !usedPaths[p] {
+				add(CleanUnusedFile, p, "", "no node reads this file")
+			}
+		}
+	}
+	if _, ok := rules[CleanUnusedKey]; ok && len(logged) > 0 {
+		used := map[string]bool{}
+		for _, l := range logged {
+			used[l.Key] = true
+		}
+		for _, p := range files {
+			if !usedPaths[p] {
+				continue
+			}
+			for key := range yamlCache.Get(p).Content {
+				if !used[key] && !IsReservedHieraKey(key) {
+					add(CleanUnusedKey, p, key, fmt.Sprintf("%s is never looked up", key))
+				}
+			}
+		}
+	}
+	for id, d := range duplicates {
+		relative := []string{}
+		for _, p := range d.Paths {
+			relative = append(relative, relativeToDatadir(conf.DataDir, p))
+		}
+		add(CleanDuplicate, d.Paths[0], d.Key, fmt.Sprintf("%s has the same value in %s (nodes: %d)",
+			d.Key, strings.Join(relative, ", "), duplicateNodes[id]))
+	}
+	report.summarize()
+	return report
+}
+
+// yamlKeyLines returns the line of every top level key in the file
+func yamlKeyLines(path string) map[string]int {
+	lines := map[string]int{}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return lines
+	}
+	_, _, blocks, err := parseYamlKeyBlocks(content)
+	if err != nil {
+		return lines
+	}
+	for _, b := range blocks {
+		lines[b.key.Value] = b.key.Line
+	}
+	return lines
+}
+
+func relativeToDatadir(datadir string, path string) string {
+	relative, err := filepath.Rel(datadir, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(relative)
+}
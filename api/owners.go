@@ -0,0 +1,291 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Owners maps hiera paths and keys to the teams that own them. It is read from a CODEOWNERS style file where every line
+// is a pattern followed by one or more owners. Path patterns are globs relative to the datadir, a pattern that ends
+// with a / owns everything below that directory and a pattern without a / matches the file name in any directory.
+// Patterns that start with key: own every key that starts with the rest of the pattern. Like CODEOWNERS the last
+// matching line wins, and a matching key pattern wins over the path patterns.
+type Owners struct {
+	datadir string
+	paths   []ownerRule
+	keys    []ownerRule
+}
+
+type ownerRule struct {
+	pattern string
+	owners  []string
+}
+
+// TeamReport summarises the unused keys, unused files and duplicates that a team owns
+type TeamReport struct {
+	Team        string          `json:"team"`
+	UnusedKeys  []YamlKeyPath   `json:"unused_keys"`
+	UnusedFiles []string        `json:"unused_files"`
+	Duplicates  []TeamDuplicate `json:"duplicates"`
+	Summary     TeamSummary     `json:"summary"`
+}
+
+// TeamDuplicate is a key that has the same value in several files of the hierarchy of one or more nodes
+type TeamDuplicate struct {
+	Key   string   `json:"key"`
+	Paths []string `json:"paths"`
+	Nodes int      `json:"nodes"`
+}
+
+// TeamSummary holds the counts of a team report
+type TeamSummary struct {
+	UnusedKeys  int `json:"unused_keys"`
+	UnusedFiles int `json:"unused_files"`
+	Duplicates  int `json:"duplicates"`
+}
+
+// ParseOwners reads an owners file
+func ParseOwners(file string, datadir string) (*Owners, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	o := &Owners{datadir: datadir}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s line %d: a pattern needs at least one owner", file, line)
+		}
+		rule := ownerRule{pattern: fields[0], owners: fields[1:]}
+		if strings.HasPrefix(rule.pattern, "key:") {
+			rule.pattern = strings.TrimSuffix(strings.TrimPrefix(rule.pattern, "key:"), "*")
+			o.keys = append(o.keys, rule)
+			continue
+		}
+		if _, err := path.Match(strings.TrimSuffix(rule.pattern, "/"), ""); err != nil {
+			return nil, fmt.Errorf("%s line %d: invalid pattern %s", file, line, rule.pattern)
+		}
+		o.paths = append(o.paths, rule)
+	}
+	return o, scanner.Err()
+}
+
+// LoadOwners reads the owners file from the configuration. Without an owners file nothing is owned.
+func LoadOwners(conf Conf) *Owners {
+	if conf.OwnersFile == "" {
+		return &Owners{datadir: conf.DataDir}
+	}
+	o, err := ParseOwners(conf.OwnersFile, conf.DataDir)
+	if err != nil {
+		log.Println(err.Error())
+		return &Owners{datadir: conf.DataDir}
+	}
+	return o
+}
+
+// PathOwners returns the owners of a hiera file
+func (o *Owners) PathOwners(p string) []string {
+	relative := strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(p), filepath.ToSlash(o.datadir)), "/")
+	var owners []string
+	for _, r := range o.paths {
+		if matchOwnerPath(r.pattern, relative) {
+			owners = r.owners
+		}
+	}
+	return owners
+}
+
+// KeyOwners returns the owners of a key, a key that matches no key pattern is owned by the owners of its files
+func (o *Owners) KeyOwners(key string, paths []string) []string {
+	var owners []string
+	for _, r := range o.keys {
+		if strings.HasPrefix(key, r.pattern) {
+			owners = r.owners
+		}
+	}
+	if owners != nil {
+		return owners
+	}
+	set := map[string]bool{}
+	for _, p := range paths {
+		for _, owner := range o.PathOwners(p) {
+			set[owner] = true
+		}
+	}
+	for owner := range set {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+func matchOwnerPath(pattern string, relative string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(relative, pattern)
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(relative))
+		return ok
+	}
+	ok, _ := path.Match(pattern, relative)
+	return ok
+}
+
+// Owns returns if the team is one of the owners. The @ in front of a team is optional.
+func Owns(owners []string, team string) bool {
+	team = strings.TrimPrefix(team, "@")
+	for _, owner := range owners {
+		if strings.TrimPrefix(owner, "@") == team {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Owners) annotateEntries(entries []InLogAndHieraEntry) {
+	for i, e := range entries {
+		entries[i].Owners = o.KeyOwners(e.Key, e.Paths)
+	}
+}
+
+// AnnotateCleanResult sets the owners of every key in the clean result of a node
+func (o *Owners) AnnotateCleanResult(res *YamlCleanResult) {
+	o.annotateEntries(res.InLogAndHiera)
+	o.annotateEntries(res.InHieraNotInLog)
+	o.annotateEntries(res.DuplicateData)
+	res.InLogNotInHieraOwners = map[string][]string{}
+	for _, key := range res.InLogNotInHiera {
+		if owners := o.KeyOwners(key, nil); owners != nil {
+			res.InLogNotInHieraOwners[key] = owners
+		}
+	}
+}
+
+// AnnotateCleanAllResult sets the owners of every unused key and path in the clean all result
+func (o *Owners) AnnotateCleanAllResult(res *CleanAllResult) {
+	for i, k := range res.KeysNeverUsed {
+		res.KeysNeverUsed[i].Owners = o.KeyOwners(k.Key, k.Paths)
+	}
+	res.PathOwners = map[string][]string{}
+	for _, p := range res.PathsNeverUsed {
+		if owners := o.PathOwners(p); owners != nil {
+			res.PathOwners[p] = owners
+		}
+	}
+}
+
+// TeamReportEndpoint example
+// @Summary Get the cleanup report of a team
+// @Description Summarises the unused keys and files from the clean all result and the duplicates from the stored node results that the team owns according to the owners file. Ignored keys and paths are left out.
+// @Param  team   path   string     true  "The team as it is named in the owners file, the @ is optional"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} TeamReport
+// @Failure 404 {object} APIMessage "No owners file is configured or no clean all result was found, post to the /v1/clean-all/refresh endpoint first"
+// @Failure 500 {object} APIMessage "Something went wrong getting the results"
+// @Router /reports/owner/{team} [get]
+func TeamReportEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		if conf.OwnersFile == "" {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "No owners_file is configured"})
+			return
+		}
+		owners, err := ParseOwners(conf.OwnersFile, conf.DataDir)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		report, err := GetTeamReport(conf, owners, c.Param("team"))
+		if err == ErrEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": NoCleanAllResultMessage})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// GetTeamReport collects what the team owns from the clean all result and the stored node results
+func GetTeamReport(conf Conf, owners *Owners, team string) (*TeamReport, error) {
+	report := TeamReport{
+		Team:        team,
+		UnusedKeys:  []YamlKeyPath{},
+		UnusedFiles: []string{},
+		Duplicates:  []TeamDuplicate{},
+	}
+	matcher := GetIgnoreMatcher(conf)
+	full, err := GetFullCleanResultEntry(conf.DB)
+	if full == nil {
+		return nil, err
+	}
+	res := matcher.FilterCleanAllResult(*full)
+	owners.AnnotateCleanAllResult(&res)
+	for _, k := range res.KeysNeverUsed {
+		if Owns(k.Owners, team) {
+			report.UnusedKeys = append(report.UnusedKeys, k)
+		}
+	}
+	for _, p := range res.PathsNeverUsed {
+		if Owns(res.PathOwners[p], team) {
+			report.UnusedFiles = append(report.UnusedFiles, p)
+		}
+	}
+
+	duplicates := map[string]*TeamDuplicate{}
+	err = ForEachNodeCleanResult(conf.DB, func(n NodeCleanResult) {
+		r := matcher.FilterCleanResult(n.Certname, n.Result)
+		for _, e := range r.DuplicateData {
+			if !Owns(owners.KeyOwners(e.Key, e.Paths), team) {
+				continue
+			}
+			d, ok := duplicates[e.Key]
+			if !ok {
+				d = &TeamDuplicate{Key: e.Key, Paths: []string{}}
+				duplicates[e.Key] = d
+			}
+			d.Nodes++
+			for _, p := range e.Paths {
+				if !stringInSlice(p, d.Paths) {
+					d.Paths = append(d.Paths, p)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range duplicates {
+		sort.Strings(d.Paths)
+		report.Duplicates = append(report.Duplicates, *d)
+	}
+	sort.Slice(report.Duplicates, func(i, k int) bool { return report.Duplicates[i].Key < report.Duplicates[k].Key })
+
+	report.Summary = TeamSummary{
+		UnusedKeys:  len(report.UnusedKeys),
+		UnusedFiles: len(report.UnusedFiles),
+		Duplicates:  len(report.Duplicates),
+	}
+	return &report, nil
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/akira/go-puppetdb"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ReconcileConfig holds the settings for purging the logs of nodes that are no longer in puppetdb
+type ReconcileConfig struct {
+	GraceHours int `yaml:"grace_hours"`
+}
+
+// StaleNode is a logged certname that was not found in puppetdb. It is purged when it stays missing for the grace period.
+type StaleNode struct {
+	Certname     string    `bson:"_id" json:"certname"`
+	FirstMissing time.Time `bson:"first_missing" json:"first_missing"`
+	PurgeAfter   time.Time `bson:"purge_after" json:"purge_after"`
+}
+
+// ReconcileReport shows what a reconciliation removed and which nodes are waiting for their grace period to end
+type ReconcileReport struct {
+	ID       string      `bson:"_id" json:"-"`
+	Started  time.Time   `bson:"started" json:"started"`
+	Finished time.Time   `bson:"finished" json:"finished"`
+	Checked  int         `bson:"checked" json:"checked"`
+	Removed  []string    `bson:"removed" json:"removed"`
+	Pending  []StaleNode `bson:"pending" json:"pending"`
+}
+
+// DeleteKeysForCertnameEndpoint example
+// @Summary Delete all logged keys of a host
+// @Description Removes the logged keys and runs of a host. Use this when a node is decommissioned.
+// @Param  id     path   string     true  "Some ID"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} APIMessage
+// @Failure 500 {object} APIMessage "Something went wrong deleting the entries"
+// @Router /keys/{id} [delete]
+func DeleteKeysForCertnameEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var u1 JSONID
+		c.ShouldBindUri(&u1)
+		defer c.Done()
+
+		s, err := DeleteCertnameLogEntries(conf.DB, u1.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		} else {
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": *s})
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// ReconcileRefreshEndpoint example
+// @Summary Purge the logs of nodes that are no longer active
+// @Description Compares the logged certnames with the active nodes in puppetdb. Certnames that have been missing for longer than the grace period are purged.
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} ReconcileReport
+// @Failure 500 {object} APIMessage "Something went wrong reconciling the logs"
+// @Router /reconcile/refresh [post]
+func ReconcileRefreshEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		report, err := ReconcileKeyLog(conf)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		} else {
+			c.JSON(http.StatusOK, report)
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// ReconcileEndpoint example
+// @Summary Get the report of the last reconciliation
+// @Description Shows which certnames the last reconciliation purged and which ones are waiting for their grace period to end.
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} ReconcileReport
+// @Failure 404 {object} APIMessage "No reconciliation has been done yet"
+// @Failure 500 {object} APIMessage "Something went wrong getting the report"
+// @Router /reconcile [get]
+func ReconcileEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		report, err := GetLastReconcileReport(conf.DB)
+		if err == ErrEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": err.Error()})
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		} else {
+			c.JSON(http.StatusOK, report)
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// NewPuppetDBClient creates a puppetdb client with the connection settings from the configuration
+func NewPuppetDBClient(conf Conf) *puppetdb.Client {
+	if !conf.Puppet.SSL {
+		return puppetdb.NewClient(conf.Puppet.Host, conf.Puppet.Port, false)
+	}
+	if conf.Puppet.Insecure {
+		return puppetdb.NewClientSSLInsecure(conf.Puppet.Host, conf.Puppet.Port, false)
+	}
+	return puppetdb.NewClientSSL(conf.Puppet.Host, conf.Puppet.Port, conf.Puppet.Key, conf.Puppet.Cert, conf.Puppet.Ca, false)
+}
+
+// GetActiveCertnames returns the certnames of the nodes that are active in the facts source
+func GetActiveCertnames(conf Conf) ([]string, error) {
+	nodes, err := getFactsTimestamps(conf)
+	if err != nil {
+		return nil, err
+	}
+	certnames := []string{}
+	for certname := range nodes {
+		certnames = append(certnames, certname)
+	}
+	sort.Strings(certnames)
+	return certnames, nil
+}
+
+// GetLoggedCertnames returns every certname that has keys in the key log
+func GetLoggedCertnames(d Database) ([]string, error) {
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return nil, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	values, err := dbConn.Database(d.Database).Collection("keylog").Distinct(context.TODO(), "certname", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	certnames := []string{}
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			certnames = append(certnames, str)
+		}
+	}
+	sort.Strings(certnames)
+	return certnames, nil
+}
+
+// DeleteCertnameLogEntries removes everything that was logged for a certname
+func DeleteCertnameLogEntries(d Database, certname string) (*string, error) {
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return nil, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	db := dbConn.Database(d.Database)
+	keys, err := db.Collection("keylog").DeleteMany(context.TODO(), bson.M{"certname": certname})
+	if err != nil {
+		return nil, err
+	}
+	runs, err := db.Collection("runs").DeleteMany(context.TODO(), bson.M{"certname": certname})
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Collection("nodestate").DeleteOne(context.TODO(), bson.M{"_id": certname})
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Collection("nodeclean").DeleteOne(context.TODO(), bson.M{"_id": certname})
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Collection("stale").DeleteOne(context.TODO(), bson.M{"_id": certname})
+	if err != nil {
+		return nil, err
+	}
+	str := fmt.Sprintf("Deleted %d keys and %d runs", keys.DeletedCount, runs.DeletedCount)
+	return &str, nil
+}
+
+// ReconcileKeyLog compares the logged certnames with the active nodes in puppetdb. Missing certnames are remembered
+// and purged once they have been missing for longer than the grace period. The report is stored as the last report.
+func ReconcileKeyLog(conf Conf) (*ReconcileReport, error) {
+	report := ReconcileReport{
+		ID:      "last",
+		Started: time.Now(),
+		Removed: []string{},
+		Pending: []StaleNode{},
+	}
+	active, err := GetActiveCertnames(conf)
+	if err != nil {
+		return nil, err
+	}
+	// an empty facts source is far more likely a broken connection than a decommissioned estate
+	if len(active) == 0 {
+		return nil, errors.New("The facts source returned no active nodes, refusing to purge")
+	}
+	logged, err := GetLoggedCertnames(conf.DB)
+	if err != nil {
+		return nil, err
+	}
+	report.Checked = len(logged)
+
+	dbConn, err := NewClient(conf.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	stale := dbConn.Database(conf.DB.Database).Collection("stale")
+	grace := time.Duration(conf.Reconcile.GraceHours) * time.Hour
+
+	// nodes that came back are no longer stale
+	_, err = stale.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": active}})
+	if err != nil {
+		return nil, err
+	}
+	// nodes whose keys are gone from the key log, like the ones the ttl expired, have nothing left to purge
+	_, err = stale.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$nin": logged}})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, certname := range logged {
+		if stringInSlice(certname, active) {
+			continue
+		}
+		var node StaleNode
+		err := stale.FindOneAndUpdate(context.TODO(),
+			bson.M{"_id": certname},
+			bson.M{"$setOnInsert": bson.M{"first_missing": report.Started}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&node)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		node.PurgeAfter = node.FirstMissing.Add(grace)
+		if report.Started.After(node.PurgeAfter) {
+			_, err := DeleteCertnameLogEntries(conf.DB, certname)
+			if err != nil {
+				log.Println(err.Error())
+				continue
+			}
+			report.Removed = append(report.Removed, certname)
+		} else {
+			report.Pending = append(report.Pending, node)
+		}
+	}
+	report.Finished = time.Now()
+
+	_, err = dbConn.Database(conf.DB.Database).Collection("reconcile").ReplaceOne(context.TODO(),
+		bson.M{"_id": report.ID}, report, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return &report, nil
+}
+
+// GetLastReconcileReport gets the report of the last reconciliation
+func GetLastReconcileReport(d Database) (*ReconcileReport, error) {
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return nil, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	var report ReconcileReport
+	err = dbConn.Database(d.Database).Collection("reconcile").FindOne(context.TODO(), bson.M{"_id": "last"}).Decode(&report)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrEntryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
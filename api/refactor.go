@@ -0,0 +1,397 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// KeyRefactor renames a key in every file of the datadir. With a path and a to_path the key is moved from one file of
+// the hierarchy to another instead and renamed when to is given.
+type KeyRefactor struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Path   string `json:"path"`
+	ToPath string `json:"to_path"`
+}
+
+// RefactorResult holds the changed files and the diff of a refactoring
+type RefactorResult struct {
+	Success    bool          `json:"success"`
+	Applied    bool          `json:"applied"`
+	Renamed    int           `json:"renamed"`
+	Moved      bool          `json:"moved"`
+	References int           `json:"references"`
+	Files      []CleanupFile `json:"files"`
+	Errors     []string      `json:"errors"`
+	Diff       string        `json:"diff"`
+}
+
+// RefactorKeyEndpoint example
+// @Summary Rename or move a hiera key
+// @Description Renames a key in every yaml file of the datadir, including the lookup_options and the %{lookup('key')}, %{alias('key')} and %{hiera('key')} interpolations that refer to it. With path and to_path the key is moved between two files of the hierarchy, relative to the datadir. Only the lines of the key are changed so the formatting of the files is kept. By default this is a dry run that returns the diff.
+// @Param  body   body   KeyRefactor  true  "The key to rename or move"
+// @Param  apply  query  string       false "Set to true to write the changes to the datadir"
+// @Param  format query  string       false "json (default) or diff for the plain unified diff"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} RefactorResult
+// @Failure 400 {object} APIMessage "The refactoring is not valid"
+// @Router /refactor/key [post]
+func RefactorKeyEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		var r KeyRefactor
+		if err := c.ShouldBindJSON(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		res, err := RefactorKey(conf, r)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		if c.Query("apply") == "true" {
+			res.Apply()
+		}
+		if c.Query("format") == "diff" {
+			c.Data(http.StatusOK, "text/x-diff; charset=utf-8", []byte(res.Diff))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// RefactorKey computes the changes of the refactoring without writing them. The interpolations are only rewritten
+// when no file defines the old key anymore, a key that is moved and renamed but also defined elsewhere keeps them.
+func RefactorKey(conf Conf, r KeyRefactor) (*RefactorResult, error) {
+	if r.From == "" {
+		return nil, errors.New("from is required")
+	}
+	if r.To == "" {
+		r.To = r.From
+	}
+	if (r.Path == "") != (r.ToPath == "") {
+		return nil, errors.New("path and to_path are both needed to move a key")
+	}
+	if filepath.Clean(r.Path) == filepath.Clean(r.ToPath) {
+		// a move inside one file is a rename of the key in that file
+		r.ToPath = r.Path
+	}
+	if r.To == r.From && r.Path == r.ToPath {
+		return nil, errors.New("Nothing to do, give a new name or a path to move the key to")
+	}
+	if _, err := yamlKeyName(r.To); err != nil {
+		return nil, err
+	}
+
+	res := &RefactorResult{Success: true, Files: []CleanupFile{}, Errors: []string{}}
+	contents := map[string][]byte{}
+	originals := map[string][]byte{}
+	for _, p := range ReadAllFilesYaml(conf) {
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			res.addError(err)
+			continue
+		}
+		contents[p] = content
+		originals[p] = content
+	}
+
+	stillDefined := false
+	if r.Path != "" {
+		from := filepath.ToSlash(filepath.Join(conf.DataDir, r.Path))
+		to := filepath.ToSlash(filepath.Join(conf.DataDir, r.ToPath))
+		if !insideDir(conf.DataDir, from) || !insideDir(conf.DataDir, to) {
+			return nil, errors.New("path and to_path have to be inside the datadir")
+		}
+		if _, ok := contents[from]; !ok {
+			return nil, fmt.Errorf("%s is not a yaml file in the datadir", r.Path)
+		}
+		if ext := filepath.Ext(to); ext != ".yaml" && ext != ".yml" {
+			return nil, fmt.Errorf("%s is not a yaml file", r.ToPath)
+		}
+		// a file on disk that was not read as hiera data would be overwritten
+		if _, ok := contents[to]; !ok && DoesFileExist(to) {
+			return nil, fmt.Errorf("%s exists but is not a yaml file of the datadir", r.ToPath)
+		}
+		if from == to {
+			renamed, ok, err := renameYamlKey(contents[from], r.From, r.To)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", r.Path, err.Error())
+			}
+			if !ok || !definesYamlKey(renamed, r.To) {
+				return nil, fmt.Errorf("%s: %s is not defined", r.Path, r.From)
+			}
+			contents[from] = renamed
+			res.Renamed++
+		} else {
+			rest, block, err := extractYamlKey(contents[from], r.From, r.To)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", r.Path, err.Error())
+			}
+			target, err := appendYamlKey(contents[to], r.To, block)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", r.ToPath, err.Error())
+			}
+			contents[from], contents[to] = rest, target
+			res.Moved = true
+		}
+		if definesYamlKey(contents[from], r.From) {
+			return nil, fmt.Errorf("%s: %s is still defined after the refactoring", r.Path, r.From)
+		}
+		if !definesYamlKey(contents[to], r.To) {
+			return nil, fmt.Errorf("%s: %s is not defined after the refactoring", r.ToPath, r.To)
+		}
+		for p, content := range contents {
+			if p != from && p != to && definesYamlKey(content, r.From) {
+				stillDefined = true
+			}
+		}
+	} else {
+		for p, content := range contents {
+			renamed, ok, err := renameYamlKey(content, r.From, r.To)
+			if err != nil {
+				// files that do not mention the key at all do not matter
+				if strings.Contains(string(content), r.From) {
+					res.addError(fmt.Errorf("%s: %s", p, err.Error()))
+					stillDefined = true
+				}
+				continue
+			}
+			if ok {
+				contents[p] = renamed
+				res.Renamed++
+			}
+		}
+	}
+
+	if r.To != r.From && !stillDefined {
+		for p, content := range contents {
+			rewritten, n := rewriteKeyReferences(content, r.From, r.To)
+			contents[p] = rewritten
+			res.References += n
+		}
+	}
+
+	for p, content := range contents {
+		original, existed := originals[p]
+		if existed && string(original) == string(content) {
+			continue
+		}
+		res.Files = append(res.Files, CleanupFile{Path: p, Keys: []string{r.To}, original: original, cleaned: content})
+	}
+	sort.Slice(res.Files, func(i, k int) bool { return res.Files[i].Path < res.Files[k].Path })
+	diff, errs := unifiedDiff(conf.DataDir, res.Files)
+	for _, err := range errs {
+		res.addError(err)
+	}
+	res.Diff = diff
+	return res, nil
+}
+
+// Apply writes the changed files to the datadir
+func (r *RefactorResult) Apply() {
+	for _, err := range applyFiles(r.Files) {
+		r.addError(err)
+	}
+	r.Applied = true
+}
+
+func (r *RefactorResult) addError(err error) {
+	r.Success = false
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// definesYamlKey returns if the content has the key at the top level
+func definesYamlKey(content []byte, key string) bool {
+	_, _, blocks, err := parseYamlKeyBlocks(content)
+	if err != nil {
+		return false
+	}
+	return findYamlKeyBlock(blocks, key) != nil
+}
+
+func findYamlKeyBlock(blocks []yamlKeyBlock, key string) *yamlKeyBlock {
+	for i := range blocks {
+		if blocks[i].key.Value == key {
+			return &blocks[i]
+		}
+	}
+	return nil
+}
+
+// renameYamlKey renames the top level key and its entry in the lookup_options. It returns if anything was renamed.
+// The renamed content is parsed again so a rename never leaves a file that is not valid yaml.
+func renameYamlKey(content []byte, from string, to string) ([]byte, bool, error) {
+	_, lines, blocks, err := parseYamlKeyBlocks(content)
+	if err != nil {
+		return content, false, err
+	}
+	renamed := false
+	if b := findYamlKeyBlock(blocks, from); b != nil {
+		if from != to && findYamlKeyBlock(blocks, to) != nil {
+			return content, false, fmt.Errorf("%s can not be renamed, %s already exists", from, to)
+		}
+		if err := renameKeyOnLine(lines, b.key, to); err != nil {
+			return content, false, err
+		}
+		renamed = true
+	}
+	if b := findYamlKeyBlock(blocks, "lookup_options"); b != nil && b.value.Kind == yaml.MappingNode {
+		for i := 0; i < len(b.value.Content); i += 2 {
+			if b.value.Content[i].Value != from {
+				continue
+			}
+			if err := renameKeyOnLine(lines, b.value.Content[i], to); err != nil {
+				return content, false, err
+			}
+			renamed = true
+		}
+	}
+	out := []byte(strings.Join(lines, ""))
+	if !renamed {
+		return out, false, nil
+	}
+	if _, _, blocks, err = parseYamlKeyBlocks(out); err != nil {
+		return content, false, fmt.Errorf("the file would no longer be valid yaml: %s", err.Error())
+	}
+	if findYamlKeyBlock(blocks, from) != nil && from != to && findYamlKeyBlock(blocks, to) == nil {
+		return content, false, fmt.Errorf("%s could not be renamed to %s", from, to)
+	}
+	return out, true, nil
+}
+
+// yamlKeyName returns the key as it has to be written in a yaml file, quoted when it is not a plain string
+func yamlKeyName(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, "\r\n") {
+		return "", fmt.Errorf("%q is not a valid key", key)
+	}
+	out, err := yaml.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// renameKeyOnLine replaces the key on its line and keeps its quotes, a new name that can not be written with the same
+// quotes is quoted the way yaml needs it
+func renameKeyOnLine(lines []string, key *yaml.Node, to string) error {
+	line := lines[key.Line-1]
+	col := key.Column - 1
+	if col < 0 || col >= len(line) {
+		return fmt.Errorf("%s could not be found on line %d", key.Value, key.Line)
+	}
+	name, err := yamlKeyName(to)
+	if err != nil {
+		return err
+	}
+	raw := key.Value
+	if q := line[col]; q == '\'' || q == '"' {
+		end := strings.IndexByte(line[col+1:], q)
+		if end < 0 {
+			return fmt.Errorf("%s could not be found on line %d", key.Value, key.Line)
+		}
+		raw = line[col : col+end+2]
+		if !strings.ContainsAny(to, "'\"\\") {
+			name = string(q) + to + string(q)
+		}
+	} else if !strings.HasPrefix(line[col:], raw) {
+		return fmt.Errorf("%s could not be found on line %d", key.Value, key.Line)
+	}
+	lines[key.Line-1] = line[:col] + name + line[col+len(raw):]
+	return nil
+}
+
+// extractYamlKey removes the key with the comments right above it and returns the content without it and the lines of
+// the key renamed to to
+func extractYamlKey(content []byte, from string, to string) ([]byte, []string, error) {
+	root, lines, blocks, err := parseYamlKeyBlocks(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	index := -1
+	for i := range blocks {
+		if blocks[i].key.Value == from {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, nil, fmt.Errorf("%s is not defined", from)
+	}
+	b := blocks[index]
+	usesAlias := false
+	walkYamlNode(b.value, func(n *yaml.Node) {
+		if n.Kind == yaml.AliasNode {
+			usesAlias = true
+		}
+	})
+	if usesAlias {
+		return nil, nil, fmt.Errorf("%s uses an alias and can not be moved to another file", from)
+	}
+	if anchor := usedAnchor(b.value, root, index*2); anchor != "" {
+		return nil, nil, fmt.Errorf("%s defines the anchor &%s that is still used", from, anchor)
+	}
+
+	block := append([]string{}, lines[b.from:b.to]...)
+	if err := renameKeyOnLine(block, &yaml.Node{Value: b.key.Value, Line: b.key.Line - b.from, Column: b.key.Column}, to); err != nil {
+		return nil, nil, err
+	}
+	for len(block) > 0 && strings.TrimSpace(block[len(block)-1]) == "" {
+		block = block[:len(block)-1]
+	}
+	rest, removed, errs := RemoveYamlKeys(content, []string{from})
+	if len(errs) > 0 {
+		return nil, nil, errs[0]
+	}
+	if len(removed) == 0 {
+		return nil, nil, fmt.Errorf("%s could not be removed", from)
+	}
+	return rest, block, nil
+}
+
+// appendYamlKey adds the lines of a key at the end of the content, a file that does not exist yet is created
+func appendYamlKey(content []byte, key string, block []string) ([]byte, error) {
+	if content == nil {
+		content = []byte("---\n")
+	}
+	if definesYamlKey(content, key) {
+		return nil, fmt.Errorf("%s already exists", key)
+	}
+	out := string(content)
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	if strings.TrimSpace(out) != "---" && strings.TrimSpace(out) != "" {
+		out += "\n"
+	}
+	out += strings.Join(block, "")
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	var check yaml.Node
+	if err := yaml.Unmarshal([]byte(out), &check); err != nil {
+		return nil, fmt.Errorf("the file would no longer be valid yaml: %s", err.Error())
+	}
+	return []byte(out), nil
+}
+
+// rewriteKeyReferences rewrites the lookup, alias and hiera interpolations of the key, including the ones that dig
+// into it with a dot and the ones with escaped quotes in a double quoted string. It returns the new content and the
+// amount of rewritten references.
+func rewriteKeyReferences(content []byte, from string, to string) ([]byte, int) {
+	re := regexp.MustCompile(`(%\{\s*(?:lookup|alias|hiera)\(\s*\\?['"])` + regexp.QuoteMeta(from) + `((?:\.[^'"\\]*)?\\?['"]\s*\)\s*\})`)
+	n := len(re.FindAllIndex(content, -1))
+	if n == 0 {
+		return content, 0
+	}
+	return re.ReplaceAll(content, []byte("${1}"+strings.ReplaceAll(to, "$", "$$")+"${2}")), n
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenameYamlKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		from    string
+		to      string
+		want    string
+		renamed bool
+		err     bool
+	}{
+		{
+			name:    "plain key",
+			content: "---\nold::param: 1\nother: 2\n",
+			from:    "old::param",
+			to:      "new::param",
+			want:    "---\nnew::param: 1\nother: 2\n",
+			renamed: true,
+		},
+		{
+			name:    "quoted key keeps its quotes",
+			content: "'old::param': 1\n\"other\": 2\n",
+			from:    "old::param",
+			to:      "new::param",
+			want:    "'new::param': 1\n\"other\": 2\n",
+			renamed: true,
+		},
+		{
+			name:    "lookup_options entry",
+			content: "lookup_options:\n  old::param:\n    merge: deep\nold::param:\n  a: 1\n",
+			from:    "old::param",
+			to:      "new::param",
+			want:    "lookup_options:\n  new::param:\n    merge: deep\nnew::param:\n  a: 1\n",
+			renamed: true,
+		},
+		{
+			name:    "name that needs quotes",
+			content: "old::param: 1\n",
+			from:    "old::param",
+			to:      "new: param",
+			want:    "'new: param': 1\n",
+			renamed: true,
+		},
+		{
+			name:    "name that would be a number",
+			content: "old::param: 1\n",
+			from:    "old::param",
+			to:      "123",
+			want:    "\"123\": 1\n",
+			renamed: true,
+		},
+		{
+			name:    "quote in a single quoted key",
+			content: "'old::param': 1\n",
+			from:    "old::param",
+			to:      "it's",
+			want:    "it's: 1\n",
+			renamed: true,
+		},
+		{
+			name:    "key not in the file",
+			content: "other: 2\n",
+			from:    "old::param",
+			to:      "new::param",
+			want:    "other: 2\n",
+		},
+		{
+			name:    "new name already exists",
+			content: "old::param: 1\nnew::param: 2\n",
+			from:    "old::param",
+			to:      "new::param",
+			err:     true,
+		},
+		{
+			name:    "name with a newline",
+			content: "old::param: 1\n",
+			from:    "old::param",
+			to:      "new\nparam",
+			err:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, renamed, err := renameYamlKey([]byte(tt.content), tt.from, tt.to)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if renamed != tt.renamed {
+				t.Errorf("renamed = %v, want %v", renamed, tt.renamed)
+			}
+			if string(out) != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+			if tt.renamed && !definesYamlKey(out, tt.to) {
+				t.Errorf("%q does not define %s", out, tt.to)
+			}
+		})
+	}
+}
+
+func TestExtractYamlKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		from    string
+		to      string
+		rest    string
+		block   string
+		err     bool
+	}{
+		{
+			name:    "key with its comment",
+			content: "---\nfoo: 1\n# the servers\nntp::servers:\n  - a\n  - b\nbar: 2\n",
+			from:    "ntp::servers",
+			to:      "time::servers",
+			rest:    "---\nfoo: 1\nbar: 2\n",
+			block:   "# the servers\ntime::servers:\n  - a\n  - b\n",
+		},
+		{
+			name:    "renamed to a name that needs quotes",
+			content: "foo: 1\nbar: 2\n",
+			from:    "bar",
+			to:      "a: b",
+			rest:    "foo: 1\n",
+			block:   "'a: b': 2\n",
+		},
+		{
+			name:    "key not defined",
+			content: "foo: 1\n",
+			from:    "bar",
+			to:      "bar",
+			err:     true,
+		},
+		{
+			name:    "key uses an alias",
+			content: "base: &base\n  a: 1\nbar: *base\n",
+			from:    "bar",
+			to:      "bar",
+			err:     true,
+		},
+		{
+			name:    "anchor still used",
+			content: "base: &base\n  a: 1\nbar: *base\n",
+			from:    "base",
+			to:      "base",
+			err:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rest, block, err := extractYamlKey([]byte(tt.content), tt.from, tt.to)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", rest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(rest) != tt.rest {
+				t.Errorf("rest = %q, want %q", rest, tt.rest)
+			}
+			if got := strings.Join(block, ""); got != tt.block {
+				t.Errorf("block = %q, want %q", got, tt.block)
+			}
+		})
+	}
+}
+
+func TestRewriteKeyReferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		count   int
+	}{
+		{
+			name:    "lookup alias and hiera",
+			content: "a: \"%{lookup('old::param')}\"\nb: \"%{alias('old::param')}\"\nc: \"%{hiera(\\\"old::param\\\")}\"\n",
+			want:    "a: \"%{lookup('new::param')}\"\nb: \"%{alias('new::param')}\"\nc: \"%{hiera(\\\"new::param\\\")}\"\n",
+			count:   3,
+		},
+		{
+			name:    "dig into the key",
+			content: "a: \"%{lookup('old::param.users.0')}\"\n",
+			want:    "a: \"%{lookup('new::param.users.0')}\"\n",
+			count:   1,
+		},
+		{
+			name:    "other keys with the same prefix",
+			content: "a: \"%{lookup('old::parameter')}\"\nb: \"old::param\"\n",
+			want:    "a: \"%{lookup('old::parameter')}\"\nb: \"old::param\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, n := rewriteKeyReferences([]byte(tt.content), "old::param", "new::param")
+			if string(out) != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+			if n != tt.count {
+				t.Errorf("count = %d, want %d", n, tt.count)
+			}
+		})
+	}
+}
+
+func TestRefactorKeyMove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refactor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"common.yaml":  "old::param: 1\nref: \"%{lookup('old::param')}\"\n",
+		"nodes.yaml":   "foo: 1\n",
+		"secrets.json": "{}\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := Conf{DataDir: dir, HieraFile: filepath.Join(dir, "hiera.yaml")}
+
+	res, err := RefactorKey(conf, KeyRefactor{From: "old::param", To: "new::param", Path: "common.yaml", ToPath: "./common.yaml"})
+	if err != nil {
+		t.Fatalf("moving inside one file: %s", err)
+	}
+	if len(res.Files) != 1 || string(res.Files[0].cleaned) != "new::param: 1\nref: \"%{lookup('new::param')}\"\n" {
+		t.Errorf("moving inside one file gave %+v", res.Files)
+	}
+
+	for _, toPath := range []string{"secrets.json", "data.eyaml", "../outside.yaml"} {
+		if _, err := RefactorKey(conf, KeyRefactor{From: "old::param", Path: "common.yaml", ToPath: toPath}); err == nil {
+			t.Errorf("moving to %s should fail", toPath)
+		}
+	}
+}
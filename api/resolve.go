@@ -0,0 +1,450 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The merge behaviours of a lookup
+const (
+	MergeFirst  = "first"
+	MergeUnique = "unique"
+	MergeHash   = "hash"
+	MergeDeep   = "deep"
+)
+
+// maxInterpolationDepth stops interpolations that refer to each other
+const maxInterpolationDepth = 10
+
+var interpolationRegex = regexp.MustCompile(`%\{([^}]*)\}`)
+var interpolationFunctionRegex = regexp.MustCompile(`^\s*(lookup|hiera|alias|literal|scope)\(\s*['"]([^'"]*)['"]\s*\)\s*$`)
+
+// ResolveRequest holds the facts of a node that does not have to exist. The facts can be structured like the output of
+// facter or flat with dots like os.family.
+type ResolveRequest struct {
+	Certname    string                 `json:"certname"`
+	Environment string                 `json:"environment"`
+	Facts       map[string]interface{} `json:"facts"`
+	// Keys are the keys to look up, by default every key in the files of the hierarchy. Only used by the lookup.
+	Keys []string `json:"keys"`
+}
+
+// ResolvedPath is a path of the hierarchy and whether it exists on disk
+type ResolvedPath struct {
+	Path   string `json:"path"`
+	Exists bool   `json:"exists"`
+}
+
+// HierarchyResolveResult holds the hierarchy translated with the posted facts. The missing facts are used by the
+// hierarchy but were not posted, the paths that use them are not translated.
+type HierarchyResolveResult struct {
+	Success      bool           `json:"success"`
+	Certname     string         `json:"certname"`
+	Environment  string         `json:"environment"`
+	Paths        []ResolvedPath `json:"paths"`
+	MissingFacts []string       `json:"missing_facts"`
+}
+
+// ResolvedValue is the effective value of a key. Paths are the files that make up the value, the first one wins.
+type ResolvedValue struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	Merge string      `json:"merge"`
+	Paths []string    `json:"paths"`
+}
+
+// LookupResolveResult holds the hierarchy and the effective values of the keys for the posted facts
+type LookupResolveResult struct {
+	HierarchyResolveResult
+	Values   []ResolvedValue `json:"values"`
+	NotFound []string        `json:"not_found"`
+}
+
+// ResolveHierarchyEndpoint example
+// @Summary Translate the hierarchy with posted facts
+// @Description Translates the hierarchies in your hiera file into paths with the posted facts instead of the facts of an existing node, and shows which paths exist on disk. Without an environment the environment fact or else the configured env is used. Only the configured env can be resolved, its datadir and hiera file are the ones arvo reads.
+// @Param  body   body   ResolveRequest  true  "The facts of the node"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} HierarchyResolveResult
+// @Failure 400 {object} APIMessage "The facts are not valid or the environment is not the configured env"
+// @Router /hierarchy/resolve [post]
+func ResolveHierarchyEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		var r ResolveRequest
+		if err := c.ShouldBindJSON(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		res, err := ResolveHierarchyForFacts(conf, r)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// ResolveLookupEndpoint example
+// @Summary Look up keys with posted facts
+// @Description Returns the effective values of the keys for a node with the posted facts, the node does not have to exist. The lookup_options of the files set the merge behaviour, first, unique, hash or deep, and the facts and lookup interpolations in the values are replaced.
+// @Param  body   body   ResolveRequest  true  "The facts of the node and the keys to look up"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} LookupResolveResult
+// @Failure 400 {object} APIMessage "The facts are not valid or the environment is not the configured env"
+// @Router /lookup/resolve [post]
+func ResolveLookupEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		var r ResolveRequest
+		if err := c.ShouldBindJSON(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		res, err := ResolveLookupForFacts(conf, r)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// ResolveHierarchyForFacts translates the hierarchy with the facts of the request. Only the configured environment can
+// be resolved, the datadir and hiera file of other environments are not known.
+func ResolveHierarchyForFacts(conf Conf, r ResolveRequest) (HierarchyResolveResult, error) {
+	res, _, err := resolveHierarchyForFacts(conf, r)
+	return res, err
+}
+
+func resolveHierarchyForFacts(conf Conf, r ResolveRequest) (HierarchyResolveResult, map[string]interface{}, error) {
+	if r.Facts == nil {
+		r.Facts = map[string]interface{}{}
+	}
+	facts := FlattenFacts(r.Certname, r.Facts)
+	if facts["trusted.certname"] == "" {
+		delete(facts, "trusted.certname")
+	}
+	if r.Environment != "" {
+		facts["environment"] = r.Environment
+	} else if _, ok := facts["environment"]; !ok {
+		facts["environment"] = conf.PuppetEnv
+	}
+	if env := fmt.Sprint(facts["environment"]); env != conf.PuppetEnv {
+		return HierarchyResolveResult{}, nil, fmt.Errorf("Only the %s environment can be resolved, the datadir and hiera file of %s are not configured", conf.PuppetEnv, env)
+	}
+
+	h := GetPathsAndVarsInHierarchy(conf)
+	res := HierarchyResolveResult{
+		Success:      true,
+		Certname:     fmt.Sprint(facts["trusted.certname"]),
+		Environment:  fmt.Sprint(facts["environment"]),
+		Paths:        []ResolvedPath{},
+		MissingFacts: []string{},
+	}
+	if _, ok := facts["trusted.certname"]; !ok {
+		res.Certname = ""
+	}
+	for _, v := range h.Variables {
+		if _, ok := hierarchyFact(facts, v); !ok && !stringInSlice(v, res.MissingFacts) {
+			res.MissingFacts = append(res.MissingFacts, v)
+		}
+	}
+	sort.Strings(res.MissingFacts)
+	for _, p := range ResolveHierarchy(h, facts).Paths {
+		res.Paths = append(res.Paths, ResolvedPath{Path: p, Exists: DoesFileExist(p)})
+	}
+	return res, facts, nil
+}
+
+// ResolveLookupForFacts looks up the keys of the request in the hierarchy translated with its facts
+func ResolveLookupForFacts(conf Conf, r ResolveRequest) (LookupResolveResult, error) {
+	h, facts, err := resolveHierarchyForFacts(conf, r)
+	if err != nil {
+		return LookupResolveResult{}, err
+	}
+	res := LookupResolveResult{HierarchyResolveResult: h, Values: []ResolvedValue{}, NotFound: []string{}}
+
+	l := hieraLookup{facts: facts, options: map[string]interface{}{}}
+	for _, p := range h.Paths {
+		if p.Exists {
+			l.files = append(l.files, yamlCache.Get(p.Path))
+		}
+	}
+	// the lookup_options are merged over the whole hierarchy with the higher levels winning
+	for i := len(l.files) - 1; i >= 0; i-- {
+		if options, ok := normalizeYamlValue(l.files[i].Content["lookup_options"]).(map[string]interface{}); ok {
+			for k, v := range options {
+				l.options[k] = v
+			}
+		}
+	}
+
+	keys := r.Keys
+	if len(keys) == 0 {
+		seen := map[string]bool{"lookup_options": true}
+		for _, f := range l.files {
+			for k := range f.Content {
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+		}
+		sort.Strings(keys)
+	}
+	for _, k := range keys {
+		v, found := l.lookup(k, 0)
+		if !found {
+			res.NotFound = append(res.NotFound, k)
+			continue
+		}
+		res.Values = append(res.Values, v)
+	}
+	return res, nil
+}
+
+// hieraLookup looks up keys in the files of a hierarchy, the first file has the highest priority
+type hieraLookup struct {
+	files   []YamlMapEntry
+	facts   map[string]interface{}
+	options map[string]interface{}
+}
+
+func (l hieraLookup) lookup(key string, depth int) (ResolvedValue, bool) {
+	// dotted keys dig into the value of the first part
+	root := key
+	var dig []string
+	if !l.defined(key) && strings.Contains(key, ".") {
+		parts := strings.Split(key, ".")
+		root, dig = parts[0], parts[1:]
+	}
+	res := ResolvedValue{Key: key, Merge: l.mergeBehaviour(root), Paths: []string{}}
+	found := false
+	for _, f := range l.files {
+		raw, ok := f.Content[root]
+		if !ok {
+			continue
+		}
+		value := l.interpolate(normalizeYamlValue(raw), depth)
+		if !found {
+			res.Value = value
+			found = true
+		} else {
+			res.Value = mergeValues(res.Value, value, res.Merge)
+		}
+		res.Paths = append(res.Paths, f.Path)
+		if res.Merge == MergeFirst {
+			break
+		}
+	}
+	if !found {
+		return res, false
+	}
+	if res.Merge == MergeUnique {
+		res.Value = mergeValues([]interface{}{}, res.Value, MergeUnique)
+	}
+	for _, d := range dig {
+		m, ok := res.Value.(map[string]interface{})
+		if !ok {
+			return res, false
+		}
+		if res.Value, ok = m[d]; !ok {
+			return res, false
+		}
+	}
+	return res, true
+}
+
+func (l hieraLookup) defined(key string) bool {
+	for _, f := range l.files {
+		if _, ok := f.Content[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeBehaviour returns the merge strategy of the lookup_options of the key, the options can match the key by name or
+// by a regular expression starting with ^
+func (l hieraLookup) mergeBehaviour(key string) string {
+	options, ok := l.options[key]
+	if !ok {
+		for pattern, o := range l.options {
+			if !strings.HasPrefix(pattern, "^") {
+				continue
+			}
+			if re, err := regexp.Compile(pattern); err == nil && re.MatchString(key) {
+				options, ok = o, true
+				break
+			}
+		}
+	}
+	m, isMap := options.(map[string]interface{})
+	if !ok || !isMap {
+		return MergeFirst
+	}
+	switch merge := m["merge"].(type) {
+	case string:
+		return validMerge(merge)
+	case map[string]interface{}:
+		if strategy, ok := merge["strategy"].(string); ok {
+			return validMerge(strategy)
+		}
+	}
+	return MergeFirst
+}
+
+func validMerge(merge string) string {
+	switch merge {
+	case MergeUnique, MergeHash, MergeDeep:
+		return merge
+	}
+	return MergeFirst
+}
+
+// interpolate replaces the facts and the lookup, hiera, alias, literal and scope interpolations in the strings of the
+// value. Interpolations that cannot be resolved are left as they are.
+func (l hieraLookup) interpolate(value interface{}, depth int) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range v {
+			m[k] = l.interpolate(val, depth)
+		}
+		return m
+	case []interface{}:
+		s := []interface{}{}
+		for _, val := range v {
+			s = append(s, l.interpolate(val, depth))
+		}
+		return s
+	case string:
+		// an alias is replaced by the value of the other key with its type
+		if match := interpolationRegex.FindStringSubmatch(v); match != nil && match[0] == strings.TrimSpace(v) {
+			if f := interpolationFunctionRegex.FindStringSubmatch(match[1]); f != nil && f[1] == "alias" && depth < maxInterpolationDepth {
+				if resolved, ok := l.lookup(f[2], depth+1); ok {
+					return resolved.Value
+				}
+			}
+		}
+		return interpolationRegex.ReplaceAllStringFunc(v, func(s string) string {
+			expr := interpolationRegex.FindStringSubmatch(s)[1]
+			if f := interpolationFunctionRegex.FindStringSubmatch(expr); f != nil {
+				switch f[1] {
+				case "literal":
+					return f[2]
+				case "scope":
+					expr = f[2]
+				default:
+					if depth >= maxInterpolationDepth {
+						return s
+					}
+					if resolved, ok := l.lookup(f[2], depth+1); ok {
+						return fmt.Sprint(resolved.Value)
+					}
+					return s
+				}
+			}
+			name := strings.TrimPrefix(strings.TrimSpace(expr), "::")
+			name = strings.TrimPrefix(name, "facts.")
+			if val, ok := l.facts[name]; ok {
+				return fmt.Sprint(val)
+			}
+			return s
+		})
+	}
+	return value
+}
+
+// mergeValues merges the value of a lower level into the value of a higher level
+func mergeValues(higher interface{}, lower interface{}, merge string) interface{} {
+	switch merge {
+	case MergeUnique:
+		merged := []interface{}{}
+		for _, v := range append(asSlice(higher), asSlice(lower)...) {
+			if !containsValue(merged, v) {
+				merged = append(merged, v)
+			}
+		}
+		return merged
+	case MergeHash, MergeDeep:
+		h, ok1 := higher.(map[string]interface{})
+		l, ok2 := lower.(map[string]interface{})
+		if !ok1 || !ok2 {
+			if merge == MergeDeep && isSlice(higher) && isSlice(lower) {
+				return mergeValues(higher, lower, MergeUnique)
+			}
+			return higher
+		}
+		merged := map[string]interface{}{}
+		for k, v := range l {
+			merged[k] = v
+		}
+		for k, v := range h {
+			if lv, ok := merged[k]; ok && merge == MergeDeep {
+				if _, isMap := v.(map[string]interface{}); isMap {
+					v = mergeValues(v, lv, MergeDeep)
+				}
+			}
+			merged[k] = v
+		}
+		return merged
+	}
+	return higher
+}
+
+func asSlice(value interface{}) []interface{} {
+	if s, ok := value.([]interface{}); ok {
+		return s
+	}
+	return []interface{}{value}
+}
+
+func isSlice(value interface{}) bool {
+	_, ok := value.([]interface{})
+	return ok
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if fmt.Sprint(v) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeYamlValue turns the maps with interface keys of the yaml parser into maps with string keys so the value
+// can be merged and written as json. The value in the yaml cache is not changed.
+func normalizeYamlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range v {
+			m[fmt.Sprint(k)] = normalizeYamlValue(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range v {
+			m[k] = normalizeYamlValue(val)
+		}
+		return m
+	case []interface{}:
+		s := []interface{}{}
+		for _, val := range v {
+			s = append(s, normalizeYamlValue(val))
+		}
+		return s
+	}
+	return value
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// GetRunsForCertnameEndpoint example
+// @Summary Get the logged puppet runs of a host
+// @Description Shows every logged run of a host, newest first, with the keys that were looked up during that run. Only lookups that were posted with a run_id are grouped in runs.
+// @Param  id     path   string     true  "Some ID"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []HieraRun
+// @Failure 500 {object} APIMessage "Something went wrong getting the runs"
+// @Router /runs/{id} [get]
+func GetRunsForCertnameEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var u1 JSONID
+		c.ShouldBindUri(&u1)
+		defer c.Done()
+
+		runs, err := GetRunsForCertname(conf.DB, u1.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		} else {
+			c.JSON(http.StatusOK, runs)
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// GetRunDiffEndpoint example
+// @Summary Compare the looked up keys of two runs
+// @Description Shows the keys that were added or are no longer looked up between two runs of the same host. Without parameters the latest run is compared to the one before it.
+// @Param  id     path   string     true  "Some ID"
+// @Param  from   query  string     false "The run id to compare from"
+// @Param  to     query  string     false "The run id to compare to"
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} HieraRunDiff
+// @Failure 404 {object} APIMessage "The runs were not found"
+// @Failure 500 {object} APIMessage "Something went wrong getting the runs"
+// @Router /runs/{id}/diff [get]
+func GetRunDiffEndpoint(conf Conf) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var u1 JSONID
+		c.ShouldBindUri(&u1)
+		defer c.Done()
+
+		runs, err := GetRunsForCertname(conf.DB, u1.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		from, to, err := selectRunsToCompare(runs, c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, DiffRuns(*from, *to))
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// selectRunsToCompare picks the runs by id, the runs must be sorted newest first. When no ids are given the latest
+// run is compared with the run before it.
+func selectRunsToCompare(runs []HieraRun, fromID string, toID string) (*HieraRun, *HieraRun, error) {
+	find := func(id string) *HieraRun {
+		for i := range runs {
+			if runs[i].RunID == id {
+				return &runs[i]
+			}
+		}
+		return nil
+	}
+	var from, to *HieraRun
+	if toID != "" {
+		to = find(toID)
+	} else if len(runs) > 0 {
+		to = &runs[0]
+	}
+	if fromID != "" {
+		from = find(fromID)
+	} else if to != nil {
+		for i := range runs {
+			if runs[i].FirstSeen.Before(to.FirstSeen) {
+				from = &runs[i]
+				break
+			}
+		}
+	}
+	if from == nil || to == nil {
+		return nil, nil, errors.New("Two runs are needed to make a comparison")
+	}
+	return from, to, nil
+}
+
+// DiffRuns returns the keys that were looked up in to but not in from and the other way around
+func DiffRuns(from HieraRun, to HieraRun) HieraRunDiff {
+	diff := HieraRunDiff{
+		Certname: to.Certname,
+		From:     from.RunID,
+		To:       to.RunID,
+		Added:    []string{},
+		Removed:  []string{},
+	}
+	for _, k := range to.Keys {
+		if !stringInSlice(k, from.Keys) {
+			diff.Added = append(diff.Added, k)
+		}
+	}
+	for _, k := range from.Keys {
+		if !stringInSlice(k, to.Keys) {
+			diff.Removed = append(diff.Removed, k)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// GetRunsForCertname gets all the logged runs of a certname with the newest run first
+func GetRunsForCertname(d Database, certname string) ([]HieraRun, error) {
+	runs := []HieraRun{}
+	dbConn, err := NewClient(d)
+	if err != nil {
+		return runs, err
+	}
+	defer dbConn.Disconnect(context.TODO())
+	collection := dbConn.Database(d.Database).Collection("runs")
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "first_seen", Value: -1}})
+	cur, err := collection.Find(context.TODO(), bson.M{"certname": certname}, findOptions)
+	if err != nil {
+		return runs, err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var elem HieraRun
+		err := cur.Decode(&elem)
+		if err != nil {
+			log.Println(err.Error())
+		} else {
+			sort.Strings(elem.Keys)
+			runs = append(runs, elem)
+		}
+	}
+	return runs, cur.Err()
+}
+
+// runUpsertModel adds the looked up key to its run, the run is created when it is the first key
+func runUpsertModel(e HieraHostDBLogEntry) mongo.WriteModel {
+	seen := e.seenAt()
+	update := bson.M{
+		"$setOnInsert": bson.M{"certname": e.Certname, "run_id": e.RunID},
+		"$min":         bson.M{"first_seen": seen},
+		"$max":         bson.M{"last_seen": seen},
+		"$addToSet":    bson.M{"keys": e.Key},
+	}
+	if e.Environment != "" {
+		update["$set"] = bson.M{"environment": e.Environment}
+	}
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"_id": e.Certname + "/" + e.RunID}).
+		SetUpdate(update).
+		SetUpsert(true)
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The job types the scheduler starts next to the clean-all refresh
+const (
+	JobInfluxExport = "influx-export"
+	JobUnusedFiles  = "unused-files"
+	JobClasses      = "classes"
+	JobPurgeLogs    = "purge-logs"
+)
+
+// ScheduleConfig holds the cron expressions of the periodic jobs. An empty expression disables the job.
+// Besides the five cron fields descriptors like @daily and @every 2h are accepted.
+type ScheduleConfig struct {
+	CleanAll     string `yaml:"clean_all"`
+	CleanNodes   string `yaml:"clean_nodes"`
+	InfluxExport string `yaml:"influx_export"`
+	UnusedFiles  string `yaml:"unused_files"`
+	Classes      string `yaml:"classes"`
+	PurgeLogs    string `yaml:"purge_logs"`
+}
+
+// ScheduledTask shows when a scheduled job ran last and when it runs next
+type ScheduledTask struct {
+	Name      string     `json:"name"`
+	Schedule  string     `json:"schedule"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastJobID string     `json:"last_job_id,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+}
+
+// Scheduler starts the configured jobs on their cron schedule. A job that is still running when it is due again
+// is skipped.
+type Scheduler struct {
+	cron  *cron.Cron
+	jobs  *JobManager
+	mu    sync.Mutex
+	tasks map[string]*scheduledTask
+}
+
+type scheduledTask struct {
+	status  ScheduledTask
+	entryID cron.EntryID
+}
+
+// NewScheduler registers every job that has a schedule. It fails when a cron expression can not be parsed.
+func NewScheduler(conf Conf, jobs *JobManager) (*Scheduler, error) {
+	s := &Scheduler{
+		cron:  cron.New(),
+		jobs:  jobs,
+		tasks: map[string]*scheduledTask{},
+	}
+	// the influx jobs only run when there is an influxdb to write to
+	influx := func(run func(ctx context.Context, job *Job) error) func(ctx context.Context, job *Job) error {
+		if !conf.UseInflux {
+			return nil
+		}
+		return run
+	}
+	tasks := []struct {
+		name     string
+		schedule string
+		run      func(ctx context.Context, job *Job) error
+	}{
+		{JobCleanAll, conf.Schedule.CleanAll, func(ctx context.Context, job *Job) error {
+			return CleanAll(ctx, conf, TimeWindow{}, false, job)
+		}},
+		{JobCleanNodes, conf.Schedule.CleanNodes, func(ctx context.Context, job *Job) error {
+			return RefreshNodeCleanResults(ctx, conf, TimeWindow{}, false, job)
+		}},
+		{JobInfluxExport, conf.Schedule.InfluxExport, influx(func(ctx context.Context, job *Job) error {
+			return ExportToInfluxDB(ctx, conf, job)
+		})},
+		{JobUnusedFiles, conf.Schedule.UnusedFiles, influx(func(ctx context.Context, job *Job) error {
+			exportFilesToInfluxDB(conf)
+			return nil
+		})},
+		{JobClasses, conf.Schedule.Classes, influx(func(ctx context.Context, job *Job) error {
+			exportUnusedClassesToInflux(conf)
+			return nil
+		})},
+		{JobPurgeLogs, conf.Schedule.PurgeLogs, func(ctx context.Context, job *Job) error {
+			_, err := ReconcileKeyLog(conf)
+			return err
+		}},
+	}
+	for _, t := range tasks {
+		if t.schedule == "" {
+			continue
+		}
+		if t.run == nil {
+			log.Printf("The %s job is scheduled but use_influx is off, it will not run", t.name)
+			continue
+		}
+		err := s.add(t.name, t.schedule, t.run)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Scheduler) add(name string, schedule string, run func(ctx context.Context, job *Job) error) error {
+	task := &scheduledTask{status: ScheduledTask{Name: name, Schedule: schedule}}
+	id, err := s.cron.AddFunc(schedule, func() {
+		job, err := s.jobs.Start(name, run)
+		now := time.Now()
+		s.mu.Lock()
+		task.status.LastRun = &now
+		task.status.LastJobID = job.ID
+		task.status.LastError = ""
+		if err != nil {
+			task.status.LastError = err.Error()
+		}
+		s.mu.Unlock()
+		if err != nil {
+			log.Printf("Skipping the scheduled %s job: %s", name, err.Error())
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("Invalid schedule %q for %s: %s", schedule, name, err.Error())
+	}
+	task.entryID = id
+	s.tasks[name] = task
+	return nil
+}
+
+// Start starts running the jobs on their schedule
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop stops scheduling new runs, jobs that are running are not stopped
+func (s *Scheduler) Stop() {
+	s.cron.Stop()
+}
+
+// Status returns the scheduled jobs sorted by name
+func (s *Scheduler) Status() []ScheduledTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks := []ScheduledTask{}
+	for _, t := range s.tasks {
+		status := t.status
+		next := s.cron.Entry(t.entryID).Next
+		if !next.IsZero() {
+			status.NextRun = &next
+		}
+		// a failed job only shows up on the job itself
+		if status.LastJobID != "" && status.LastError == "" {
+			if job, ok := s.jobs.Get(status.LastJobID); ok {
+				snapshot := job.Snapshot()
+				if snapshot.State == JobFailed {
+					status.LastError = snapshot.Message
+				}
+			}
+		}
+		tasks = append(tasks, status)
+	}
+	sort.Slice(tasks, func(i, k int) bool { return tasks[i].Name < tasks[k].Name })
+	return tasks
+}
+
+// ScheduleEndpoint example
+// @Summary Get the scheduled jobs
+// @Description Shows every scheduled job with its cron expression, when it ran last, the job id of that run and when it runs next.
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []ScheduledTask
+// @Router /schedule [get]
+func ScheduleEndpoint(s *Scheduler) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		defer c.Done()
+		c.JSON(http.StatusOK, s.Status())
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StreamStats are the counters of the lookup stream as they are shown by the metrics endpoint
+type StreamStats struct {
+	Clients   int    `json:"clients"`
+	Published uint64 `json:"published"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// KeyStream sends every ingested lookup to the clients connected to the stream endpoint. Every client has its own
+// bounded buffer, when a client can not keep up lookups are dropped for that client only.
+type KeyStream struct {
+	mu         sync.RWMutex
+	clients    map[*keyStreamClient]bool
+	bufferSize int
+	done       chan struct{}
+	closeOnce  sync.Once
+	published  uint64
+	dropped    uint64
+}
+
+type keyStreamClient struct {
+	certname string
+	prefix   string
+	events   chan HieraHostDBLogEntry
+}
+
+// NewKeyStream creates a stream where each client buffers at most bufferSize lookups
+func NewKeyStream(bufferSize int) *KeyStream {
+	return &KeyStream{
+		clients:    map[*keyStreamClient]bool{},
+		bufferSize: bufferSize,
+		done:       make(chan struct{}),
+	}
+}
+
+// Close ends the stream of every connected client and of the clients that connect after it, so a shutdown of the server
+// does not wait on them
+func (s *KeyStream) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// Publish sends the lookup to every client whose filter matches. It never blocks.
+func (s *KeyStream) Publish(e HieraHostDBLogEntry) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	atomic.AddUint64(&s.published, 1)
+	for cl := range s.clients {
+		if !cl.matches(e) {
+			continue
+		}
+		select {
+		case cl.events <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Stats returns a snapshot of the stream counters
+func (s *KeyStream) Stats() StreamStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return StreamStats{
+		Clients:   len(s.clients),
+		Published: atomic.LoadUint64(&s.published),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+	}
+}
+
+func (s *KeyStream) subscribe(certname string, prefix string) *keyStreamClient {
+	cl := &keyStreamClient{
+		certname: certname,
+		prefix:   prefix,
+		events:   make(chan HieraHostDBLogEntry, s.bufferSize),
+	}
+	s.mu.Lock()
+	s.clients[cl] = true
+	s.mu.Unlock()
+	return cl
+}
+
+func (s *KeyStream) unsubscribe(cl *keyStreamClient) {
+	s.mu.Lock()
+	delete(s.clients, cl)
+	s.mu.Unlock()
+}
+
+func (cl *keyStreamClient) matches(e HieraHostDBLogEntry) bool {
+	if cl.certname != "" && cl.certname != e.Certname {
+		return false
+	}
+	return strings.HasPrefix(e.Key, cl.prefix)
+}
+
+// KeyStreamEndpoint example
+// @Summary Stream the logged lookups
+// @Description Pushes every ingested lookup to the client as a server-sent event named lookup. A ping event is sent every 15 seconds to keep the connection open. The stream ends when arvo shuts down.
+// @Param  certname  query  string     false "Only stream the lookups of this certname"
+// @Param  prefix    query  string     false "Only stream the keys that start with this prefix"
+// @Produce  text/event-stream
+// @Success 200 {object} HieraHostDBLogEntry "A stream of lookups"
+// @Router /keys/stream [get]
+func KeyStreamEndpoint(stream *KeyStream) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		cl := stream.subscribe(c.Query("certname"), c.Query("prefix"))
+		defer stream.unsubscribe(cl)
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case e := <-cl.events:
+				c.SSEvent("lookup", e)
+				return true
+			case t := <-ticker.C:
+				c.SSEvent("ping", t.Format(LAYOUT))
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			case <-stream.done:
+				return false
+			}
+		})
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -11,10 +12,22 @@ import (
 	"math"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// ErrEntryNotFound is returned when the database has no entry, so callers can tell it apart from a failing database
+var ErrEntryNotFound = errors.New("Entry not found")
+
+// reservedHieraKeys are read by hiera itself and never show up as a lookup, they are never unused
+var reservedHieraKeys = map[string]bool{"lookup_options": true}
+
+// IsReservedHieraKey tells if hiera reserves the key for itself
+func IsReservedHieraKey(key string) bool {
+	return reservedHieraKeys[key]
+}
+
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
@@ -231,3 +244,58 @@ func isStringArray(anArray []interface{}) bool {
 
 	return check
 }
+
+// ParseTimeParam parses the since and until query parameters. It accepts RFC3339, the LAYOUT used by the key log,
+// a date or a duration back from now such as 30d or 12h.
+func ParseTimeParam(str string) (*time.Time, error) {
+	if str == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, LAYOUT, "2006-01-02"} {
+		t, err := time.Parse(layout, str)
+		if err == nil {
+			return &t, nil
+		}
+	}
+	if strings.HasSuffix(str, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(str, "d"))
+		if err == nil {
+			t := time.Now().AddDate(0, 0, -days)
+			return &t, nil
+		}
+	}
+	d, err := time.ParseDuration(str)
+	if err == nil {
+		t := time.Now().Add(-d)
+		return &t, nil
+	}
+	return nil, fmt.Errorf("Could not parse time %s use RFC3339, a date or a duration like 30d", str)
+}
+
+// GetTimeWindowFromQuery reads the since and until query parameters into a TimeWindow
+func GetTimeWindowFromQuery(c *gin.Context) (TimeWindow, error) {
+	w := TimeWindow{}
+	since, err := ParseTimeParam(c.Query("since"))
+	if err != nil {
+		return w, err
+	}
+	until, err := ParseTimeParam(c.Query("until"))
+	if err != nil {
+		return w, err
+	}
+	w.Since = since
+	w.Until = until
+	return w, nil
+}
+
+// filter returns the mongo filter for the key log records that were looked up inside the window
+func (w TimeWindow) filter() bson.M {
+	filter := bson.M{}
+	if w.Since != nil {
+		filter["last_seen"] = bson.M{"$gte": *w.Since}
+	}
+	if w.Until != nil {
+		filter["first_seen"] = bson.M{"$lte": *w.Until}
+	}
+	return filter
+}
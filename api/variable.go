@@ -355,7 +355,7 @@ func GetVirtualHierachyForNode(conf Conf, certname string) (*HierarchyResult, er
 
 	facts := GetFactsMapForCertName(conf, certname)
 	if len(facts) == 0 {
-		return nil, errors.New("No facts found for node are you sure node exists or PuppetDB connection is valid")
+		return nil, errors.New("No facts found for node are you sure node exists or the facts source is valid")
 
 	} else {
 		for _, v := range h.Variables {
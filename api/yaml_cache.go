@@ -0,0 +1,46 @@
+package api
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// YamlFileCache keeps parsed hiera files in memory. A file is parsed again when its modification time or size changed.
+type YamlFileCache struct {
+	mu    sync.RWMutex
+	files map[string]cachedYamlFile
+}
+
+type cachedYamlFile struct {
+	modTime time.Time
+	size    int64
+	entry   YamlMapEntry
+}
+
+// yamlCache is shared by every refresh so unchanged files are only parsed once
+var yamlCache = NewYamlFileCache()
+
+// NewYamlFileCache creates an empty cache
+func NewYamlFileCache() *YamlFileCache {
+	return &YamlFileCache{files: map[string]cachedYamlFile{}}
+}
+
+// Get returns the parsed file. Files that do not exist are not cached and return an empty entry.
+func (c *YamlFileCache) Get(path string) YamlMapEntry {
+	info, err := os.Stat(path)
+	if err != nil {
+		return GetYamlMapEntryFromPath(path)
+	}
+	c.mu.RLock()
+	cached, ok := c.files[path]
+	c.mu.RUnlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.entry
+	}
+	entry := GetYamlMapEntryFromPath(path)
+	c.mu.Lock()
+	c.files[path] = cachedYamlFile{modTime: info.ModTime(), size: info.Size(), entry: entry}
+	c.mu.Unlock()
+	return entry
+}
@@ -35,6 +35,14 @@ var doc = `{
                     "application/json"
                 ],
                 "summary": "Returns the clean all result if it has been generated",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Set to false to show the keys and paths that are hidden by the ignore rules",
+                        "name": "ignore",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "The clean all result.",
@@ -43,7 +51,7 @@ var doc = `{
                         }
                     },
                     "404": {
-                        "description": "No entry was found run the /v1/clean-all/refresh endpoint first",
+                        "description": "No entry was found, post to the /v1/clean-all/refresh endpoint first",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
@@ -57,25 +65,45 @@ var doc = `{
                 }
             }
         },
-        "/clean-all/refresh": {
+        "/clean-all/diff": {
             "get": {
-                "description": "As parsing your whole environment may take a while this job starts doing the process in the background. You will get a json that that says the process has started",
+                "description": "Shows the keys and paths that became unused or are no longer unused between two snapshots. Without parameters the latest snapshot is compared to the one before it.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Starts generating an entry for the clean all result.",
+                "summary": "Compare two clean all results",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The snapshot id to compare from",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The snapshot id to compare to",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
-                        "description": "Gathering result may take a while check the clean endpoint for the result.",
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.CleanAllDiff"
+                        }
+                    },
+                    "404": {
+                        "description": "The snapshots were not found",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
                     },
                     "500": {
-                        "description": "Something went wrong getting the entries",
+                        "description": "Something went wrong getting the history",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
@@ -83,34 +111,28 @@ var doc = `{
                 }
             }
         },
-        "/clean/{id}": {
+        "/clean-all/history": {
             "get": {
-                "description": "Looks trough you logged entries and hierarchy files to find unused keys etc. That will help you clean up hiera data.",
+                "description": "Lists every stored clean all result, newest first, with the amount of unused keys and paths.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get the clean result for a certname",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Some ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
-                ],
+                "summary": "Get the history of the clean all results",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.YamlCleanResult"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.CleanAllSnapshot"
+                            }
                         }
                     },
                     "500": {
-                        "description": "Something went wrong getting the entry",
+                        "description": "Something went wrong getting the history",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
@@ -118,25 +140,40 @@ var doc = `{
                 }
             }
         },
-        "/hiera/path": {
+        "/clean-all/history/{id}": {
             "get": {
-                "description": "Gets all the ids of your paths so you can see which hiera paths are available.",
+                "description": "Shows a clean all result from the history with its unused keys and paths.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get all hiera path ids",
+                "summary": "Get one stored clean all result",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.APIArrayMessage"
+                            "$ref": "#/definitions/api.CleanAllSnapshot"
+                        }
+                    },
+                    "404": {
+                        "description": "The snapshot was not found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
                         }
                     },
                     "500": {
-                        "description": "Something went wrong getting the entry",
+                        "description": "Something went wrong getting the snapshot",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
@@ -144,134 +181,178 @@ var doc = `{
                 }
             }
         },
-        "/hiera/path/{id}": {
-            "get": {
-                "description": "Get the data from one hiera path",
+        "/clean-all/refresh": {
+            "post": {
+                "description": "As parsing your whole environment may take a while this starts a job that does the process in the background. You will get the id of the job, follow it on the jobs endpoint. Only one refresh runs at a time.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get a hiera path",
+                "summary": "Starts generating an entry for the clean all result.",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Some ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Only count keys looked up after this time (RFC3339, date or duration like 30d)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only count keys looked up before this time (RFC3339, date or duration like 30d)",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Resolve the hierarchy of every node again instead of only the changed ones",
+                        "name": "full",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "202": {
+                        "description": "Gathering result may take a while check the job for its progress.",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/api.JobMessage"
                         }
                     },
-                    "500": {
-                        "description": "Something went wrong getting the entry",
+                    "409": {
+                        "description": "A refresh is already running",
                         "schema": {
-                            "$ref": "#/definitions/api.APIMessage"
+                            "$ref": "#/definitions/api.JobMessage"
                         }
                     }
                 }
-            },
-            "put": {
-                "description": "Creates a new hiera path entry if it does not exist yet.",
+            }
+        },
+        "/clean-nodes": {
+            "get": {
+                "description": "Finds the nodes whose stored clean result has the key in the category, or more than the given amount of keys in the category. Without filters every node is returned. The nodes are sorted by certname. The filters and counts are applied to the stored results before the ignore rules.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Updates an existing hiera path entry",
+                "summary": "Query the stored clean results of the nodes",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Some ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "in_log_not_in_hiera, in_log_and_hiera, in_hiera_not_in_log or duplicates",
+                        "name": "category",
+                        "in": "query"
                     },
                     {
-                        "description": "data",
-                        "name": "data",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/api.HieraDataExample"
-                        }
+                        "type": "string",
+                        "description": "Only nodes that have this key in the category",
+                        "name": "key",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only nodes with more than this amount of keys in the category",
+                        "name": "more_than",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The page, starting at 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The amount of nodes per page, 50 by default",
+                        "name": "per_page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Set to false to show the keys that are hidden by the ignore rules",
+                        "name": "ignore",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.NodeCleanPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid parameters",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error",
+                        "description": "Something went wrong getting the results",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/clean-nodes/refresh": {
             "post": {
-                "description": "Creates a new hiera path entry if it does not exist yet.",
+                "description": "Starts a job that computes the clean result of every logged certname and every active node and stores it. Follow the job on the jobs endpoint. Only one refresh runs at a time.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Creates a hiera path entry",
+                "summary": "Starts storing the clean result of every node",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Some ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Only count keys looked up after this time (RFC3339, date or duration like 30d)",
+                        "name": "since",
+                        "in": "query"
                     },
                     {
-                        "description": "data",
-                        "name": "data",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/api.HieraDataExample"
-                        }
+                        "type": "string",
+                        "description": "Only count keys looked up before this time (RFC3339, date or duration like 30d)",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Resolve the hierarchy of every node again instead of only the changed ones",
+                        "name": "full",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "202": {
+                        "description": "Gathering result may take a while check the job for its progress.",
                         "schema": {
-                            "$ref": "#/definitions/api.APIMessage"
+                            "$ref": "#/definitions/api.JobMessage"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "409": {
+                        "description": "A refresh is already running",
                         "schema": {
-                            "$ref": "#/definitions/api.APIMessage"
+                            "$ref": "#/definitions/api.JobMessage"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Deletes a hiera path entry",
+            }
+        },
+        "/clean-nodes/{id}": {
+            "get": {
+                "description": "Shows the clean result of a certname as it was stored by the last refresh of the node clean results.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Delete a hiera path",
+                "summary": "Get the stored clean result of a node",
                 "parameters": [
                     {
                         "type": "string",
@@ -279,17 +360,23 @@ var doc = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Set to false to show the keys that are hidden by the ignore rules",
+                        "name": "ignore",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.APIMessage"
+                            "$ref": "#/definitions/api.NodeCleanResult"
                         }
                     },
-                    "500": {
-                        "description": "Something went wrong getting the entry",
+                    "404": {
+                        "description": "No result was stored for the node",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
@@ -297,38 +384,48 @@ var doc = `{
                 }
             }
         },
-        "/hiera/value/{id}/{certname}": {
+        "/clean/{id}": {
             "get": {
-                "description": "Get the data from one hiera path",
+                "description": "Looks trough you logged entries and hierarchy files to find unused keys etc. That will help you clean up hiera data.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get a hiera path",
+                "summary": "Get the clean result for a certname",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Some key",
+                        "description": "Some ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
                         "type": "string",
-                        "description": "Some certname",
-                        "name": "certname",
-                        "in": "path",
-                        "required": true
+                        "description": "Only count keys looked up after this time (RFC3339, date or duration like 30d)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only count keys looked up before this time (RFC3339, date or duration like 30d)",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Set to false to show the keys that are hidden by the ignore rules",
+                        "name": "ignore",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/api.YamlCleanResult"
                         }
                     },
                     "500": {
@@ -340,25 +437,31 @@ var doc = `{
                 }
             }
         },
-        "/hiera/variable/hierarchy": {
-            "get": {
-                "description": "Gets all the variable paths that are in your configuration file.",
+        "/cleanup/apply": {
+            "post": {
+                "description": "Creates the same patch as the dry run and writes it to the datadir. Files that could not be changed are listed in the errors.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get all variable paths in your configuration",
+                "summary": "Remove the unused keys and files from the datadir",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.APIArrayMessage"
+                            "$ref": "#/definitions/api.CleanupPatch"
+                        }
+                    },
+                    "404": {
+                        "description": "No clean all result was found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
                         }
                     },
                     "500": {
-                        "description": "Something went wrong getting the entry",
+                        "description": "Something went wrong getting the clean all result",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
@@ -366,34 +469,39 @@ var doc = `{
                 }
             }
         },
-        "/hiera/variable/hierarchy/{id}": {
+        "/cleanup/patch": {
             "get": {
-                "description": "Translates the",
+                "description": "This is a dry run. It removes the unused keys and paths of the clean all result from the hiera data, without the ignored ones, and returns the changes as a unified diff. Comments, ordering and anchors of the remaining data are kept. Keys that define an anchor that other keys still use are left alone.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get all variable paths for a specific host",
+                "summary": "Get a patch that removes the unused keys and files",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Some ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "json (default) or diff for the plain unified diff",
+                        "name": "format",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.HierarchyResult"
+                            "$ref": "#/definitions/api.CleanupPatch"
+                        }
+                    },
+                    "404": {
+                        "description": "No clean all result was found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
                         }
                     },
                     "500": {
-                        "description": "Something went wrong getting the entry",
+                        "description": "Something went wrong getting the clean all result",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
@@ -401,25 +509,27 @@ var doc = `{
                 }
             }
         },
-        "/hiera/variable/path": {
-            "get": {
-                "description": "Gets all the ids of your your variable paths",
+        "/facts/cache": {
+            "delete": {
+                "description": "Drops the cached facts of every node, or only of the node given as certname, so they are fetched again from the facts source.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get all variable path ids",
+                "summary": "Flush the facts cache",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only flush the facts of this node",
+                        "name": "certname",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/api.APIArrayMessage"
-                        }
-                    },
-                    "500": {
-                        "description": "Something went wrong getting the entry",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
@@ -427,92 +537,202 @@ var doc = `{
                 }
             }
         },
-        "/hiera/variable/path/{id}": {
+        "/git/blame": {
             "get": {
-                "description": "Get the data from one hiera path",
+                "description": "Reads the git history of the datadir to show who last changed the unused keys and files of the clean all result and when. Ignored keys and paths are left out. Lines that are not committed yet show an empty commit.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get a hiera path",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Some ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
-                ],
+                "summary": "Get the last change to every unused key and file",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/api.GitBlameReport"
+                        }
+                    },
+                    "404": {
+                        "description": "No clean all result was found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
                         }
                     },
                     "500": {
-                        "description": "Something went wrong getting the entry",
+                        "description": "The datadir is not a git checkout",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
                     }
                 }
-            },
-            "put": {
-                "description": "Creates a new variable path entry if it does not exist yet.",
+            }
+        },
+        "/git/cleanup-branch": {
+            "post": {
+                "description": "Commits the cleanup patch of the clean all result on a new branch on top of HEAD of the datadir checkout. The working tree, the index and the current branch are not touched, so the branch can be reviewed and pushed by hand. Files with uncommitted changes are left out.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Updates an existing variable path entry",
+                "summary": "Create a local branch with a cleanup commit",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Some ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "data",
-                        "name": "data",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/api.HieraDataExample"
-                        }
+                        "description": "The name of the branch, by default the branch_prefix with the current time",
+                        "name": "branch",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.APIMessage"
+                            "$ref": "#/definitions/api.GitCleanupBranch"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "400": {
+                        "description": "The branch name is not valid",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
-                    }
-                }
+                    },
+                    "404": {
+                        "description": "No clean all result was found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "409": {
+                        "description": "The branch already exists",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong creating the branch",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/hiera/path": {
+            "get": {
+                "description": "Gets all the ids of your paths so you can see which hiera paths are available.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get all hiera path ids",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIArrayMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the entry",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/hiera/path/{id}": {
+            "get": {
+                "description": "Get the data from one hiera path",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get a hiera path",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the entry",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Creates a new hiera path entry if it does not exist yet.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Updates an existing hiera path entry",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "data",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.HieraDataExample"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
             },
             "post": {
-                "description": "Creates a new variable path entry if it does not exist yet",
+                "description": "Creates a new hiera path entry if it does not exist yet.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Creates a variable path entry",
+                "summary": "Creates a hiera path entry",
                 "parameters": [
                     {
                         "type": "string",
@@ -545,52 +765,73 @@ var doc = `{
                         }
                     }
                 }
-            }
-        },
-        "/hierarchy": {
-            "get": {
-                "description": "Reads all the hierarchies from your hiera file and returns them.",
+            },
+            "delete": {
+                "description": "Deletes a hiera path entry",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Shows the hierarchies in your hiera.yaml file",
+                "summary": "Delete a hiera path",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.HierarchyResult"
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the entry",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
                         }
                     }
                 }
             }
         },
-        "/hierarchy/{id}": {
+        "/hiera/value/{id}/{certname}": {
             "get": {
-                "description": "Transaltes the hierarchies in your hiera file into actual paths. By getting the facts from puppetdb.",
+                "description": "Get the data from one hiera path",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get the hierachies for a specific host.",
+                "summary": "Get a hiera path",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Some ID",
+                        "description": "Some key",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Some certname",
+                        "name": "certname",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.HierarchyResult"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "500": {
@@ -602,63 +843,86 @@ var doc = `{
                 }
             }
         },
-        "/keys": {
+        "/hiera/variable/hierarchy": {
             "get": {
-                "description": "Shows you all the logged hiera keys from all the hosts that logged keys.",
+                "description": "Gets all the variable paths that are in your configuration file.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get all logged keys for all hosts",
+                "summary": "Get all variable paths in your configuration",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/api.HieraHostDBEntry"
-                            }
+                            "$ref": "#/definitions/api.APIArrayMessage"
                         }
                     },
                     "500": {
-                        "description": "Something went wrong getting the entries",
+                        "description": "Something went wrong getting the entry",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
                     }
                 }
-            },
-            "post": {
-                "description": "This logs a hiera key from puppet. Older log entries will be deleted.",
+            }
+        },
+        "/hiera/variable/hierarchy/{id}": {
+            "get": {
+                "description": "Translates the",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Log a looked up hiera key",
+                "summary": "Get all variable paths for a specific host",
                 "parameters": [
                     {
-                        "description": "Log",
-                        "name": "log",
-                        "in": "body",
-                        "required": true,
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.HieraHostDBLogEntry"
+                            "$ref": "#/definitions/api.HierarchyResult"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the entry",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
                         }
                     }
+                }
+            }
+        },
+        "/hiera/variable/path": {
+            "get": {
+                "description": "Gets all the ids of your your variable paths",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
                 ],
+                "summary": "Get all variable path ids",
                 "responses": {
                     "200": {
-                        "description": "Inserted one entries",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.APIMessage"
+                            "$ref": "#/definitions/api.APIArrayMessage"
                         }
                     },
                     "500": {
-                        "description": "Something went wrong creating the log entry",
+                        "description": "Something went wrong getting the entry",
                         "schema": {
                             "$ref": "#/definitions/api.APIMessage"
                         }
@@ -666,16 +930,16 @@ var doc = `{
                 }
             }
         },
-        "/keys/{id}": {
+        "/hiera/variable/path/{id}": {
             "get": {
-                "description": "Shows you all the logged hiera keys for one host",
+                "description": "Get the data from one hiera path",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get all logged keys for one entry",
+                "summary": "Get a hiera path",
                 "parameters": [
                     {
                         "type": "string",
@@ -689,7 +953,8 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.HieraHostDBEntry"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "500": {
@@ -699,111 +964,2171 @@ var doc = `{
                         }
                     }
                 }
-            }
-        }
-    },
-    "definitions": {
-        "api.APIArrayMessage": {
-            "type": "object",
-            "properties": {
-                "message": {
-                    "type": "array",
-                    "items": {
-                        "type": "string"
-                    }
-                },
-                "success": {
-                    "type": "boolean"
-                }
-            }
+            },
+            "put": {
+                "description": "Creates a new variable path entry if it does not exist yet.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Updates an existing variable path entry",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "data",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.HieraDataExample"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Creates a new variable path entry if it does not exist yet",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Creates a variable path entry",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "data",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.HieraDataExample"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/hierarchy": {
+            "get": {
+                "description": "Reads all the hierarchies from your hiera file and returns them.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Shows the hierarchies in your hiera.yaml file",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.HierarchyResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/hierarchy/resolve": {
+            "post": {
+                "description": "Translates the hierarchies in your hiera file into paths with the posted facts instead of the facts of an existing node, and shows which paths exist on disk. Without an environment the environment fact or else the configured env is used. Only the configured env can be resolved, its datadir and hiera file are the ones arvo reads.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Translate the hierarchy with posted facts",
+                "parameters": [
+                    {
+                        "description": "The facts of the node",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.ResolveRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.HierarchyResolveResult"
+                        }
+                    },
+                    "400": {
+                        "description": "The facts are not valid or the environment is not the configured env",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/hierarchy/{id}": {
+            "get": {
+                "description": "Transaltes the hierarchies in your hiera file into actual paths. By getting the facts from puppetdb.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get the hierachies for a specific host.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.HierarchyResult"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the entry",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/ignore": {
+            "get": {
+                "description": "Lists the rules that hide intentionally unused keys and paths from the clean reports.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get the ignore rules",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.IgnoreRule"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the rules",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Adds a rule that hides keys or paths from /v1/clean, /v1/clean-all, /v1/clean-nodes and the influx export. Set at least one of key, key_glob, path_glob or certname and a reason. A rule with an expiry date stops applying after that date.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Add an ignore rule",
+                "parameters": [
+                    {
+                        "description": "The rule, the id and created date are set by arvo",
+                        "name": "rule",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.IgnoreRule"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.IgnoreRule"
+                        }
+                    },
+                    "400": {
+                        "description": "The rule is not valid",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong saving the rule",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/ignore/report": {
+            "get": {
+                "description": "Checks every rule against the stored clean all result and node results. A rule is stale when it expired or no longer matches anything, so it can be removed. Refresh the results first to get an up to date report.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get the ignore rules that are stale",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Only show the stale rules",
+                        "name": "stale",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.IgnoreRuleStatus"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the rules",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/ignore/{id}": {
+            "delete": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete an ignore rule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "The rule was not found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/jobs": {
+            "get": {
+                "description": "Lists the running jobs and the last finished ones, newest first.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get the background jobs",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.JobStatus"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/jobs/{id}": {
+            "get": {
+                "description": "Shows the state, the progress, the start and end time and the errors of a job.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get the state of a background job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.JobStatus"
+                        }
+                    },
+                    "404": {
+                        "description": "The job was not found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Asks a running job to stop. The job stops after the step it is working on.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Cancel a background job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "The job was not found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "409": {
+                        "description": "The job is not running",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/keys": {
+            "get": {
+                "description": "Shows you all the logged hiera keys from all the hosts that logged keys.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get all logged keys for all hosts",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only keys looked up after this time (RFC3339, date or duration like 30d)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only keys looked up before this time (RFC3339, date or duration like 30d)",
+                        "name": "until",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.HieraHostDBEntry"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the entries",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "This logs a hiera key from puppet. The key is queued and written to the database in the background. Entries that were not looked up within key_retention_days are removed by the database.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Log a looked up hiera key",
+                "parameters": [
+                    {
+                        "description": "Log",
+                        "name": "log",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.HieraHostDBLogEntry"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "The key was queued",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Certname or key is missing",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "429": {
+                        "description": "The queue is full try again after the Retry-After header",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong creating the log entry",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/keys/batch": {
+            "post": {
+                "description": "Logs many hiera lookups in one request. The body is either a json array or newline delimited json (application/x-ndjson). The lookups may be for one or many certnames. They are queued like the lookups posted one by one and written to the database in the background.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Log a batch of looked up hiera keys",
+                "parameters": [
+                    {
+                        "description": "Logs",
+                        "name": "log",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.HieraHostDBLogEntry"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "The result for every posted lookup",
+                        "schema": {
+                            "$ref": "#/definitions/api.KeyBatchResult"
+                        }
+                    },
+                    "400": {
+                        "description": "The body could not be read",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "429": {
+                        "description": "Some lookups did not fit in the queue, post the failed ones again after the Retry-After header",
+                        "schema": {
+                            "$ref": "#/definitions/api.KeyBatchResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/keys/export": {
+            "get": {
+                "description": "Exports the key log as newline delimited json with one logged key per line. The export can be imported again with the jsonl format of the import endpoint.",
+                "produces": [
+                    "application/x-ndjson"
+                ],
+                "summary": "Export the logged keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only keys looked up after this time (RFC3339, date or duration like 30d)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only keys looked up before this time (RFC3339, date or duration like 30d)",
+                        "name": "until",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "One logged key per line",
+                        "schema": {
+                            "$ref": "#/definitions/api.HieraHostDBLogEntry"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the entries",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/keys/import": {
+            "post": {
+                "description": "Imports lookups from a puppetserver log file or from the jsonl export of arvo into the key log, keeping their original timestamps. Post the file as the body. Puppetserver lookups are assigned to the node whose catalog was compiled on the same thread, pass certname to assign all lookups to one node.",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Import historical lookups",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "puppetserver or jsonl, puppetserver by default",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Assign every lookup of a puppetserver log to this certname",
+                        "name": "certname",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ImportResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown format or the body could not be read",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong importing the lookups",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/keys/stream": {
+            "get": {
+                "description": "Pushes every ingested lookup to the client as a server-sent event named lookup. A ping event is sent every 15 seconds to keep the connection open. The stream ends when arvo shuts down.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "summary": "Stream the logged lookups",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only stream the lookups of this certname",
+                        "name": "certname",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only stream the keys that start with this prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "A stream of lookups",
+                        "schema": {
+                            "$ref": "#/definitions/api.HieraHostDBLogEntry"
+                        }
+                    }
+                }
+            }
+        },
+        "/keys/{id}": {
+            "get": {
+                "description": "Shows you all the logged hiera keys for one host",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get all logged keys for one entry",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only keys looked up after this time (RFC3339, date or duration like 30d)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only keys looked up before this time (RFC3339, date or duration like 30d)",
+                        "name": "until",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.HieraHostDBEntry"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the entry",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Removes the logged keys and runs of a host. Use this when a node is decommissioned.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete all logged keys of a host",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong deleting the entries",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/lint": {
+            "get": {
+                "description": "Checks every yaml file in the datadir for syntax errors, tabs, duplicate keys, keys without a module namespace, keys with a different type of value in different files, empty files and files that match no level of the hierarchy. The severity of the rules is set in the lint section of the configuration.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Lint the hiera data",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Comma separated rules to run, by default all rules that are not off",
+                        "name": "rules",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only show issues with this severity, error or warning",
+                        "name": "severity",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only lint the files below this path relative to the datadir",
+                        "name": "path",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.LintReport"
+                        }
+                    },
+                    "400": {
+                        "description": "An unknown rule was given",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/lookup/resolve": {
+            "post": {
+                "description": "Returns the effective values of the keys for a node with the posted facts, the node does not have to exist. The lookup_options of the files set the merge behaviour, first, unique, hash or deep, and the facts and lookup interpolations in the values are replaced.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Look up keys with posted facts",
+                "parameters": [
+                    {
+                        "description": "The facts of the node and the keys to look up",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.ResolveRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.LookupResolveResult"
+                        }
+                    },
+                    "400": {
+                        "description": "The facts are not valid or the environment is not the configured env",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics": {
+            "get": {
+                "description": "Shows the depth of the key ingest queue and how many keys were written, rejected because the queue was full or failed to be written. Also shows the clients of the lookup stream and how many lookups were dropped for slow clients, and the hit rate of the facts cache.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Shows the internal metrics of arvo",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.Metrics"
+                        }
+                    }
+                }
+            }
+        },
+        "/reconcile": {
+            "get": {
+                "description": "Shows which certnames the last reconciliation purged and which ones are waiting for their grace period to end.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get the report of the last reconciliation",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ReconcileReport"
+                        }
+                    },
+                    "404": {
+                        "description": "No reconciliation has been done yet",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the report",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/reconcile/refresh": {
+            "post": {
+                "description": "Compares the logged certnames with the active nodes in puppetdb. Certnames that have been missing for longer than the grace period are purged.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Purge the logs of nodes that are no longer active",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ReconcileReport"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong reconciling the logs",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/refactor/key": {
+            "post": {
+                "description": "Renames a key in every yaml file of the datadir, including the lookup_options and the %{lookup('key')}, %{alias('key')} and %{hiera('key')} interpolations that refer to it. With path and to_path the key is moved between two files of the hierarchy, relative to the datadir. Only the lines of the key are changed so the formatting of the files is kept. By default this is a dry run that returns the diff.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Rename or move a hiera key",
+                "parameters": [
+                    {
+                        "description": "The key to rename or move",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.KeyRefactor"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to true to write the changes to the datadir",
+                        "name": "apply",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "json (default) or diff for the plain unified diff",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.RefactorResult"
+                        }
+                    },
+                    "400": {
+                        "description": "The refactoring is not valid",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/reports/owner/{team}": {
+            "get": {
+                "description": "Summarises the unused keys and files from the clean all result and the duplicates from the stored node results that the team owns according to the owners file. Ignored keys and paths are left out.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get the cleanup report of a team",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The team as it is named in the owners file, the @ is optional",
+                        "name": "team",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.TeamReport"
+                        }
+                    },
+                    "404": {
+                        "description": "No owners file is configured or no clean all result was found, post to the /v1/clean-all/refresh endpoint first",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the results",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/runs/{id}": {
+            "get": {
+                "description": "Shows every logged run of a host, newest first, with the keys that were looked up during that run. Only lookups that were posted with a run_id are grouped in runs.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get the logged puppet runs of a host",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.HieraRun"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the runs",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/runs/{id}/diff": {
+            "get": {
+                "description": "Shows the keys that were added or are no longer looked up between two runs of the same host. Without parameters the latest run is compared to the one before it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Compare the looked up keys of two runs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Some ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The run id to compare from",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The run id to compare to",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.HieraRunDiff"
+                        }
+                    },
+                    "404": {
+                        "description": "The runs were not found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Something went wrong getting the runs",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule": {
+            "get": {
+                "description": "Shows every scheduled job with its cron expression, when it ran last, the job id of that run and when it runs next.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get the scheduled jobs",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.ScheduledTask"
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "api.APIArrayMessage": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.APIMessage": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.CleanAllDiff": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "string"
+                },
+                "from_created": {
+                    "type": "string"
+                },
+                "keys_became_unused": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "keys_no_longer_unused": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "paths_became_unused": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "paths_no_longer_unused": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "to": {
+                    "type": "string"
+                },
+                "to_created": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.CleanAllResult": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "keys_never_used": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.YamlKeyPath"
+                    }
+                },
+                "nodes": {
+                    "type": "integer"
+                },
+                "path_owners": {
+                    "description": "PathOwners holds the owners of the paths in PathsNeverUsed",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        }
+                    }
+                },
+                "paths_never_used": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "recomputed": {
+                    "type": "integer"
+                },
+                "window": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.TimeWindow"
+                }
+            }
+        },
+        "api.CleanAllSnapshot": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "nodes": {
+                    "type": "integer"
+                },
+                "result": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.CleanAllResult"
+                },
+                "unused_keys": {
+                    "type": "integer"
+                },
+                "unused_paths": {
+                    "type": "integer"
+                },
+                "window": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.TimeWindow"
+                }
+            }
+        },
+        "api.CleanupFile": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "type": "boolean"
+                },
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "path": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.CleanupPatch": {
+            "type": "object",
+            "properties": {
+                "applied": {
+                    "type": "boolean"
+                },
+                "diff": {
+                    "type": "string"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.CleanupFile"
+                    }
+                },
+                "removed_files": {
+                    "type": "integer"
+                },
+                "removed_keys": {
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.FactsCacheStats": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "integer"
+                },
+                "flushed": {
+                    "type": "integer"
+                },
+                "hit_rate": {
+                    "type": "number"
+                },
+                "hits": {
+                    "type": "integer"
+                },
+                "invalidated": {
+                    "type": "integer"
+                },
+                "misses": {
+                    "type": "integer"
+                },
+                "revalidated": {
+                    "type": "integer"
+                },
+                "ttl_seconds": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.FileBlame": {
+            "type": "object",
+            "properties": {
+                "last_change": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.GitChange"
+                },
+                "path": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.GitBlameReport": {
+            "type": "object",
+            "properties": {
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.FileBlame"
+                    }
+                },
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.KeyBlame"
+                    }
+                }
+            }
+        },
+        "api.GitChange": {
+            "type": "object",
+            "properties": {
+                "author": {
+                    "type": "string"
+                },
+                "commit": {
+                    "type": "string"
+                },
+                "date": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "summary": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.GitCleanupBranch": {
+            "type": "object",
+            "properties": {
+                "base": {
+                    "type": "string"
+                },
+                "branch": {
+                    "type": "string"
+                },
+                "commit": {
+                    "type": "string"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.CleanupFile"
+                    }
+                },
+                "removed_files": {
+                    "type": "integer"
+                },
+                "removed_keys": {
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.HieraDataExample": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "key2": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "key3": {
+                    "type": "boolean"
+                },
+                "key4": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.HieraHostDBEntry": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.HieraHostDBLogEntry"
+                    }
+                }
+            }
+        },
+        "api.HieraHostDBLogEntry": {
+            "type": "object",
+            "properties": {
+                "certname": {
+                    "type": "string"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "date_string": {
+                    "type": "string"
+                },
+                "environment": {
+                    "type": "string"
+                },
+                "file": {
+                    "type": "string"
+                },
+                "first_seen": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "level": {
+                    "type": "string"
+                },
+                "run_id": {
+                    "type": "string"
+                },
+                "value_hash": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.HieraRun": {
+            "type": "object",
+            "properties": {
+                "certname": {
+                    "type": "string"
+                },
+                "environment": {
+                    "type": "string"
+                },
+                "first_seen": {
+                    "type": "string"
+                },
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "last_seen": {
+                    "type": "string"
+                },
+                "run_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.HieraRunDiff": {
+            "type": "object",
+            "properties": {
+                "added": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "certname": {
+                    "type": "string"
+                },
+                "from": {
+                    "type": "string"
+                },
+                "removed": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.HierarchyResolveResult": {
+            "type": "object",
+            "properties": {
+                "certname": {
+                    "type": "string"
+                },
+                "environment": {
+                    "type": "string"
+                },
+                "missing_facts": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "paths": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.ResolvedPath"
+                    }
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.HierarchyResult": {
+            "type": "object",
+            "properties": {
+                "paths": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "vars": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "api.IgnoreRule": {
+            "type": "object",
+            "properties": {
+                "certname": {
+                    "type": "string"
+                },
+                "created": {
+                    "type": "string"
+                },
+                "expires": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "key_glob": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "path_glob": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.IgnoreRuleStatus": {
+            "type": "object",
+            "properties": {
+                "expired": {
+                    "type": "boolean"
+                },
+                "matches": {
+                    "type": "integer"
+                },
+                "rule": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.IgnoreRule"
+                },
+                "stale": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.ImportResult": {
+            "type": "object",
+            "properties": {
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "imported": {
+                    "type": "integer"
+                },
+                "lines": {
+                    "type": "integer"
+                },
+                "lookups": {
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "boolean"
+                },
+                "unassigned": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.InLogAndHieraEntry": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "owners": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "paths": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "winning_path": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.IngestStats": {
+            "type": "object",
+            "properties": {
+                "batches": {
+                    "type": "integer"
+                },
+                "enqueued": {
+                    "type": "integer"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "queue_capacity": {
+                    "type": "integer"
+                },
+                "queue_depth": {
+                    "type": "integer"
+                },
+                "rejected": {
+                    "type": "integer"
+                },
+                "written": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.JobMessage": {
+            "type": "object",
+            "properties": {
+                "job_id": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.JobStatus": {
+            "type": "object",
+            "properties": {
+                "done": {
+                    "type": "integer"
+                },
+                "error_count": {
+                    "type": "integer"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "finished": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "started": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.KeyBatchItemResult": {
+            "type": "object",
+            "properties": {
+                "certname": {
+                    "type": "string"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.KeyBatchResult": {
+            "type": "object",
+            "properties": {
+                "accepted": {
+                    "type": "integer"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.KeyBatchItemResult"
+                    }
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.KeyBlame": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "last_change": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.GitChange"
+                },
+                "path": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.KeyRefactor": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "to": {
+                    "type": "string"
+                },
+                "to_path": {
+                    "type": "string"
+                }
+            }
         },
-        "api.APIMessage": {
+        "api.LintIssue": {
             "type": "object",
             "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "line": {
+                    "type": "integer"
+                },
                 "message": {
                     "type": "string"
                 },
+                "path": {
+                    "type": "string"
+                },
+                "rule": {
+                    "type": "string"
+                },
+                "severity": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.LintReport": {
+            "type": "object",
+            "properties": {
+                "errors": {
+                    "type": "integer"
+                },
+                "files": {
+                    "type": "integer"
+                },
+                "issues": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.LintIssue"
+                    }
+                },
                 "success": {
                     "type": "boolean"
+                },
+                "warnings": {
+                    "type": "integer"
                 }
             }
         },
-        "api.CleanAllResult": {
+        "api.LookupResolveResult": {
             "type": "object",
             "properties": {
-                "id": {
+                "certname": {
                     "type": "string"
                 },
-                "keys_never_used": {
+                "environment": {
+                    "type": "string"
+                },
+                "missing_facts": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/api.YamlKeyPath"
+                        "type": "string"
                     }
                 },
-                "paths_never_used": {
+                "not_found": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
+                },
+                "paths": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.ResolvedPath"
+                    }
+                },
+                "success": {
+                    "type": "boolean"
+                },
+                "values": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.ResolvedValue"
+                    }
                 }
             }
         },
-        "api.HieraDataExample": {
+        "api.Metrics": {
             "type": "object",
             "properties": {
-                "key": {
+                "facts_cache": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.FactsCacheStats"
+                },
+                "ingest": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.IngestStats"
+                },
+                "stream": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.StreamStats"
+                }
+            }
+        },
+        "api.NodeCleanCounts": {
+            "type": "object",
+            "properties": {
+                "duplicates": {
+                    "type": "integer"
+                },
+                "in_hiera_not_in_log": {
+                    "type": "integer"
+                },
+                "in_log_and_hiera": {
+                    "type": "integer"
+                },
+                "in_log_not_in_hiera": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.NodeCleanPage": {
+            "type": "object",
+            "properties": {
+                "nodes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.NodeCleanResult"
+                    }
+                },
+                "page": {
+                    "type": "integer"
+                },
+                "per_page": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.NodeCleanResult": {
+            "type": "object",
+            "properties": {
+                "certname": {
                     "type": "string"
                 },
-                "key2": {
+                "counts": {
                     "type": "object",
-                    "additionalProperties": true
+                    "$ref": "#/definitions/api.NodeCleanCounts"
                 },
-                "key3": {
-                    "type": "boolean"
+                "result": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.YamlCleanResult"
                 },
-                "key4": {
+                "updated": {
+                    "type": "string"
+                },
+                "window": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.TimeWindow"
+                }
+            }
+        },
+        "api.ReconcileReport": {
+            "type": "object",
+            "properties": {
+                "checked": {
                     "type": "integer"
+                },
+                "finished": {
+                    "type": "string"
+                },
+                "pending": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.StaleNode"
+                    }
+                },
+                "removed": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "started": {
+                    "type": "string"
                 }
             }
         },
-        "api.HieraHostDBEntry": {
+        "api.RefactorResult": {
             "type": "object",
             "properties": {
-                "id": {
+                "applied": {
+                    "type": "boolean"
+                },
+                "diff": {
                     "type": "string"
                 },
-                "keys": {
+                "errors": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/api.HieraHostDBLogEntry"
+                        "type": "string"
+                    }
+                },
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.CleanupFile"
                     }
+                },
+                "moved": {
+                    "type": "boolean"
+                },
+                "references": {
+                    "type": "integer"
+                },
+                "renamed": {
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "boolean"
                 }
             }
         },
-        "api.HieraHostDBLogEntry": {
+        "api.ResolveRequest": {
             "type": "object",
             "properties": {
                 "certname": {
                     "type": "string"
                 },
-                "date_string": {
+                "environment": {
                     "type": "string"
                 },
-                "key": {
+                "facts": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "keys": {
+                    "description": "Keys are the keys to look up, by default every key in the files of the hierarchy. Only used by the lookup.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "api.ResolvedPath": {
+            "type": "object",
+            "properties": {
+                "exists": {
+                    "type": "boolean"
+                },
+                "path": {
                     "type": "string"
                 }
             }
         },
-        "api.HierarchyResult": {
+        "api.ResolvedValue": {
             "type": "object",
             "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "merge": {
+                    "type": "string"
+                },
                 "paths": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
-                "vars": {
+                "value": {
+                    "type": "object"
+                }
+            }
+        },
+        "api.ScheduledTask": {
+            "type": "object",
+            "properties": {
+                "last_error": {
+                    "type": "string"
+                },
+                "last_job_id": {
+                    "type": "string"
+                },
+                "last_run": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "next_run": {
+                    "type": "string"
+                },
+                "schedule": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.StaleNode": {
+            "type": "object",
+            "properties": {
+                "certname": {
+                    "type": "string"
+                },
+                "first_missing": {
+                    "type": "string"
+                },
+                "purge_after": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.StreamStats": {
+            "type": "object",
+            "properties": {
+                "clients": {
+                    "type": "integer"
+                },
+                "dropped": {
+                    "type": "integer"
+                },
+                "published": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.TeamDuplicate": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "nodes": {
+                    "type": "integer"
+                },
+                "paths": {
                     "type": "array",
                     "items": {
                         "type": "string"
@@ -811,17 +3136,58 @@ var doc = `{
                 }
             }
         },
-        "api.InLogAndHieraEntry": {
+        "api.TeamReport": {
             "type": "object",
             "properties": {
-                "key": {
+                "duplicates": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.TeamDuplicate"
+                    }
+                },
+                "summary": {
+                    "type": "object",
+                    "$ref": "#/definitions/api.TeamSummary"
+                },
+                "team": {
                     "type": "string"
                 },
-                "paths": {
+                "unused_files": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
+                },
+                "unused_keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.YamlKeyPath"
+                    }
+                }
+            }
+        },
+        "api.TeamSummary": {
+            "type": "object",
+            "properties": {
+                "duplicates": {
+                    "type": "integer"
+                },
+                "unused_files": {
+                    "type": "integer"
+                },
+                "unused_keys": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.TimeWindow": {
+            "type": "object",
+            "properties": {
+                "since": {
+                    "type": "string"
+                },
+                "until": {
+                    "type": "string"
                 }
             }
         },
@@ -851,6 +3217,16 @@ var doc = `{
                     "items": {
                         "type": "string"
                     }
+                },
+                "in_log_not_in_hiera_owners": {
+                    "description": "InLogNotInHieraOwners holds the owners of the keys in InLogNotInHiera that match a key pattern of the owners file",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        }
+                    }
                 }
             }
         },
@@ -860,6 +3236,12 @@ var doc = `{
                 "key": {
                     "type": "string"
                 },
+                "owners": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "paths": {
                     "type": "array",
                     "items": {
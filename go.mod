@@ -7,12 +7,15 @@ require (
 	github.com/akira/go-puppetdb v0.0.0-20200122132916-4bc34e483a6e
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751
 	github.com/gin-contrib/cors v1.3.1
-	github.com/gin-gonic/gin v1.6.3
+	github.com/gin-gonic/gin v1.7.0
 	github.com/influxdata/influxdb-client-go v1.4.0
 	github.com/jeremywohl/flatten v1.0.1
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/swaggo/files v0.0.0-20190704085106-630677cd5c14
 	github.com/swaggo/gin-swagger v1.2.0
 	github.com/swaggo/swag v1.6.5
 	go.mongodb.org/mongo-driver v1.3.2
 	gopkg.in/yaml.v2 v2.2.8
+	gopkg.in/yaml.v3 v3.0.1
 )
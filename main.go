@@ -3,6 +3,7 @@ package main
 import (
 	cmd "arvo/api"
 	"arvo/docs"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/gin-contrib/cors"
@@ -10,6 +11,10 @@ import (
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -50,8 +55,8 @@ func main() {
 		c.DataDir = "/etc/puppetlabs/code/environment/production/data"
 
 	}
-	if c.KeyTTLMinutes == 0 {
-		c.KeyTTLMinutes = 15
+	if c.KeyRetention <= 0 && c.KeyTTLMinutes <= 0 {
+		c.KeyRetention = 30
 	}
 
 	if c.HieraFile == "" {
@@ -86,11 +91,90 @@ func main() {
 	if c.InfluxInterval <= 0 {
 		c.InfluxInterval = 2
 	}
+	// keep exporting every influx_interval hours when no schedule is given for the export
+	if c.UseInflux && c.Schedule.InfluxExport == "" {
+		c.Schedule.InfluxExport = fmt.Sprintf("@every %dh", c.InfluxInterval)
+	}
 
 	if c.PuppetEnv == "" {
 		c.PuppetEnv = "production"
 	}
 
+	if c.Ingest.QueueSize <= 0 {
+		c.Ingest.QueueSize = 10000
+	}
+	if c.Ingest.Workers <= 0 {
+		c.Ingest.Workers = 2
+	}
+	if c.Ingest.BatchSize <= 0 {
+		c.Ingest.BatchSize = 500
+	}
+	if c.Ingest.FlushIntervalMs <= 0 {
+		c.Ingest.FlushIntervalMs = 1000
+	}
+	if c.Ingest.RetryAfterSeconds <= 0 {
+		c.Ingest.RetryAfterSeconds = 5
+	}
+	if c.StreamBuffer <= 0 {
+		c.StreamBuffer = 100
+	}
+	if c.ImportMaxMB <= 0 {
+		c.ImportMaxMB = 256
+	}
+	if c.Reconcile.GraceHours <= 0 {
+		c.Reconcile.GraceHours = 72
+	}
+	if c.CleanAll.Workers <= 0 {
+		c.CleanAll.Workers = 8
+	}
+	if c.CleanAll.PuppetDBConcurrency <= 0 {
+		c.CleanAll.PuppetDBConcurrency = 4
+	}
+	if c.CleanAll.History <= 0 {
+		c.CleanAll.History = 90
+	}
+	if _, err := cmd.LintRules(c.Lint, ""); err != nil {
+		log.Fatal(err)
+	}
+	if c.Facts.Source == "" {
+		c.Facts.Source = cmd.FactsSourcePuppetDB
+	}
+	if _, err := cmd.NewFactsProvider(c); err != nil {
+		log.Fatal(err)
+	}
+	// a negative ttl turns the facts cache off
+	if c.Facts.CacheTTLSeconds == 0 {
+		c.Facts.CacheTTLSeconds = 300
+	}
+	cmd.SetFactsCacheTTL(time.Duration(c.Facts.CacheTTLSeconds) * time.Second)
+	if c.Git.BranchPrefix == "" {
+		c.Git.BranchPrefix = "arvo/cleanup-"
+	}
+	// the lint and clean commands run against a checkout and exit without starting the server
+	if flag.NArg() > 0 {
+		os.Exit(cmd.RunCommand(c, flag.Args(), os.Stdout, os.Stderr))
+	}
+
+	err := cmd.EnsureKeyLogIndexes(c.DB, c.KeyRetentionDuration())
+	if err != nil {
+		log.Println(err.Error())
+	}
+	migrated, err := cmd.MigrateLegacyLogEntries(c.DB)
+	if err != nil {
+		log.Println(err.Error())
+	} else if migrated > 0 {
+		log.Printf("Migrated the logged keys of %d certnames to the new key log", migrated)
+	}
+
+	ingester := cmd.NewKeyIngester(c)
+	ingester.Start()
+	stream := cmd.NewKeyStream(c.StreamBuffer)
+	jobs := cmd.NewJobManager()
+	scheduler, err := cmd.NewScheduler(c, jobs)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	router := gin.Default()
 	host := fmt.Sprintf("%s:%d", *addr, *port)
 	hostSwag := fmt.Sprintf("%s:%d", *swaggerHost, *port)
@@ -117,16 +201,35 @@ func main() {
 	v1 := router.Group("/v1")
 	{
 
-		v1.POST("/keys", cmd.PostKeyEndpoint(c))
+		v1.POST("/keys", cmd.PostKeyEndpoint(c, ingester, stream))
+		v1.POST("/keys/batch", cmd.PostKeyBatchEndpoint(c, ingester, stream))
+		v1.POST("/keys/import", cmd.ImportKeysEndpoint(c))
+		v1.GET("/keys/export", cmd.ExportKeysEndpoint(c))
 		v1.GET("/keys", cmd.GetKeysForAllCertnamesEndpoint(c))
+		v1.GET("/keys/stream", cmd.KeyStreamEndpoint(stream))
 		v1.GET("/keys/:id", cmd.GetKeysForOneCertnamesEndpoint(c))
+		v1.DELETE("/keys/:id", cmd.DeleteKeysForCertnameEndpoint(c))
+
+		v1.POST("/reconcile/refresh", cmd.ReconcileRefreshEndpoint(c))
+		v1.GET("/reconcile", cmd.ReconcileEndpoint(c))
+
+		v1.GET("/runs/:id", cmd.GetRunsForCertnameEndpoint(c))
+		v1.GET("/runs/:id/diff", cmd.GetRunDiffEndpoint(c))
 
 		// we must be able to set our own hierarchies as well to use with the api
 		v1.GET("/hierarchy", cmd.GetHierarchyEndPoint(c))
 		v1.GET("/hierarchy/:id", cmd.GetHierarchyForCertnameEndpoint(c))
+		v1.POST("/hierarchy/resolve", cmd.ResolveHierarchyEndpoint(c))
+		v1.POST("/lookup/resolve", cmd.ResolveLookupEndpoint(c))
 
-		v1.GET("/clean-all/refresh", cmd.CleanAllRefreshEndpoint(c))
+		v1.POST("/clean-all/refresh", cmd.CleanAllRefreshEndpoint(c, jobs))
 		v1.GET("/clean-all", cmd.CleanAllEndpoint(c))
+		v1.GET("/clean-all/history", cmd.CleanAllHistoryEndpoint(c))
+		v1.GET("/clean-all/history/:id", cmd.CleanAllSnapshotEndpoint(c))
+		v1.GET("/clean-all/diff", cmd.CleanAllDiffEndpoint(c))
+		v1.POST("/clean-nodes/refresh", cmd.NodeCleanRefreshEndpoint(c, jobs))
+		v1.GET("/clean-nodes", cmd.NodeCleanQueryEndpoint(c))
+		v1.GET("/clean-nodes/:id", cmd.NodeCleanEndpoint(c))
 		v1.GET("/clean/:id", cmd.GetKeyLocationsForCertnameEndpoint(c))
 
 		v1.GET("/hiera/path", cmd.HieraIdsEndpoint(c))
@@ -146,24 +249,55 @@ func main() {
 
 		v1.GET("/hiera/value/:id/:certname", cmd.HieraValueIdEndpoint(c))
 
-	}
-	if c.UseInflux {
-		go func() {
-			for {
-				i := time.Duration(2)
-				if c.InfluxInterval != 0 {
-					i = time.Duration(c.InfluxInterval)
-				}
-				cmd.ExportToInfluxDB(c)
-				time.Sleep(i * time.Hour)
-			}
+		v1.GET("/metrics", cmd.MetricsEndpoint(ingester, stream))
+		v1.DELETE("/facts/cache", cmd.FlushFactsCacheEndpoint())
+
+		v1.GET("/reports/owner/:team", cmd.TeamReportEndpoint(c))
+		v1.GET("/cleanup/patch", cmd.CleanupPatchEndpoint(c))
+		v1.POST("/cleanup/apply", cmd.CleanupApplyEndpoint(c))
+		v1.GET("/git/blame", cmd.GitBlameEndpoint(c))
+		v1.POST("/git/cleanup-branch", cmd.GitCleanupBranchEndpoint(c))
+		v1.POST("/refactor/key", cmd.RefactorKeyEndpoint(c))
+		v1.GET("/lint", cmd.LintEndpoint(c))
+
+		v1.GET("/ignore", cmd.GetIgnoreRulesEndpoint(c))
+		v1.POST("/ignore", cmd.PostIgnoreRuleEndpoint(c))
+		v1.GET("/ignore/report", cmd.IgnoreReportEndpoint(c))
+		v1.DELETE("/ignore/:id", cmd.DeleteIgnoreRuleEndpoint(c))
+
+		v1.GET("/jobs", cmd.GetJobsEndpoint(jobs))
+		v1.GET("/jobs/:id", cmd.GetJobEndpoint(jobs))
+		v1.DELETE("/jobs/:id", cmd.CancelJobEndpoint(jobs))
+		v1.GET("/schedule", cmd.ScheduleEndpoint(scheduler))
 
-		}()
 	}
+	scheduler.Start()
 
-	err := router.Run(host)
-	if err != nil {
+	// shut down gracefully so the keys that are still queued get written
+	srv := &http.Server{Addr: host, Handler: router}
+	// closed when the running requests are done, only then the queue can be closed
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		<-quit
+		// the streams never end on their own, close them so the shutdown does not wait on them
+		stream.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println(err.Error())
+		}
+	}()
+
+	err = srv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal(err.Error())
 	}
+	<-drained
+	scheduler.Stop()
+	jobs.CancelAll()
+	ingester.Stop()
 
 }